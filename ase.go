@@ -2,14 +2,14 @@ package ase
 
 import (
 	"bytes"
-	"compress/zlib"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"io"
-	"os"
 	"reflect"
 	"sync"
 )
@@ -18,6 +18,17 @@ const (
 	ChunkSize = 128
 )
 
+var (
+	ErrInvalidChunkType  = errors.New("invalid chunk type")
+	ErrInvalidChunkSize  = errors.New("invalid chunk size")
+	ErrInvalidCelType    = errors.New("invalid cel type")
+	ErrInvalidColorDepth = errors.New("invalid color depth")
+	ErrInvalidPixels     = errors.New("invalid pixel data")
+	ErrSizeMismatch      = errors.New("size mismatch")
+	ErrTrailingData      = errors.New("trailing data")
+	ErrSkippedPixels     = errors.New("pixels skipped")
+)
+
 //
 // type AseFile struct {
 // 	CanvasWidth
@@ -36,13 +47,32 @@ const (
 type AsepriteFile struct {
 	Header Header
 	Frames []Frame
+	// Warnings lists every quirk tolerated while decoding in lenient mode.
+	Warnings []Warning
 }
 
 type Loader struct {
-	Reader io.Reader
-	Buf    []byte
-	Buffer *bytes.Buffer
-	File   *AsepriteFile
+	Reader  io.Reader
+	Buf     []byte
+	Buffer  *bytes.Buffer
+	File    *AsepriteFile
+	Options DecodeOptions
+
+	// budget is shared with the loaders of the workers, see
+	// decompressBudget.
+	budget        *budget
+	userDataDepth int
+	read          int64
+	// palette is the palette in effect for the chunks being read, colors
+	// caches it for indexed cels.
+	palette Palette
+	colors  color.Palette
+	// cels is set when DecodeOptions.Workers decode image cels in parallel.
+	cels *celQueue
+	// ctx is checked between frames and chunks, nil means no context.
+	ctx context.Context
+	// inflater is reused for every compressed chunk of the loader.
+	inflater *inflater
 }
 
 type ColorDepth uint16
@@ -53,6 +83,21 @@ const (
 	ColorDepthIndexed   ColorDepth = 8
 )
 
+// BytesPerPixel returns how many bytes a pixel takes in cel data, or 0 for
+// an unknown depth.
+func (d ColorDepth) BytesPerPixel() int {
+	switch d {
+	case ColorDepthRGBA:
+		return 4
+	case ColorDepthGrayscale:
+		return 2
+	case ColorDepthIndexed:
+		return 1
+	default:
+		return 0
+	}
+}
+
 const HeaderSize = 128
 
 type Header struct {
@@ -77,6 +122,12 @@ type Header struct {
 	_            [84]byte
 }
 
+const (
+	HeaderFlagLayerOpacityValid uint32 = 1 << 0
+	HeaderFlagGroupOpacityValid uint32 = 1 << 1
+	HeaderFlagLayersUUID        uint32 = 1 << 2
+)
+
 type Frame struct {
 	Header FrameHeader
 	Chunks []Chunk
@@ -176,7 +227,10 @@ type ChunkColorProfileICCData struct {
 
 type ChunkOldPalette struct {
 	header ChunkHeader
-	Colors []ChunkOldPaletteColor
+	// Colors is indexed by palette entry, up to the last entry a packet
+	// sets. Entries skipped by the packets are left zeroed.
+	Colors  []ChunkOldPaletteColor
+	Packets []ChunkOldPalettePacket
 }
 
 type ChunkOldPalette2 struct{ *ChunkOldPalette } // NOTE: same thing (memory-wise), but each color has values between 0-63
@@ -187,12 +241,14 @@ type ChunkOldPaletteData struct {
 }
 
 type ChunkOldPalettePacket struct {
+	// entries to skip from the end of the previous packet
 	PaletteEntriesNumber byte
-	ColorsNumber         byte
+	// 0 means 256
+	ColorsNumber byte
 }
 
 type ChunkOldPaletteColor struct {
-	// values between 0-255
+	// values between 0-255, or 0-63 in a ChunkOldPalette2
 	R byte
 	G byte
 	B byte
@@ -275,11 +331,14 @@ type ChunkPaletteEntry struct {
 	Red       byte
 	Green     byte
 	Blue      byte
+	Alpha     byte
 	ColorName string
 }
 
 const ChunkPaletteDataSize = 20
 
+const MaxPaletteEntries = 1 << 16
+
 type ChunkPaletteData struct {
 	EntriesNumber uint32
 	From          uint32
@@ -287,13 +346,14 @@ type ChunkPaletteData struct {
 	_             [8]byte
 }
 
-const ChunkPaletteEntryDataSize = 5
+const ChunkPaletteEntryDataSize = 6
 
 type ChunkPaletteEntryData struct {
 	HasName uint16
 	Red     byte
 	Green   byte
 	Blue    byte
+	Alpha   byte
 }
 
 type ChunkCelExtra struct {
@@ -517,6 +577,89 @@ type ChunkCelImage struct {
 	header ChunkHeader
 	ChunkCelData
 	ChunkCelRawImageData
+	// Warnings lists what lenient decoding had to fix in this cel. The
+	// warnings of pixels loaded lazily are returned by PixelWarnings.
+	Warnings []Warning
+
+	lazy *lazyPixels
+}
+
+// lazyPixels holds the pixel data of a cel read with PixelModeLazy or
+// PixelModeSkip until LoadPixels asks for it.
+type lazyPixels struct {
+	once       sync.Once
+	header     Header
+	options    DecodeOptions
+	frame      int
+	compressed bool
+	skipped    bool
+	// budget is the decompression budget of the whole file.
+	budget     *budget
+	data       []byte
+	palette    color.Palette
+
+	pixels   Pixels
+	warnings []Warning
+	err      error
+}
+
+// LoadPixels returns the pixels of the cel. A cel read with PixelModeLazy
+// is decoded on the first call, and a cel read with PixelModeSkip fails
+// with ErrSkippedPixels. The Pixels field of such cels stays nil, and
+// assigning it yourself has no effect, build a new ChunkCelImage instead.
+// LoadPixels may be called from several goroutines.
+func (c *ChunkCelImage) LoadPixels() (Pixels, error) {
+	if c.lazy == nil {
+		return c.Pixels, nil
+	}
+
+	c.lazy.once.Do(func() { c.lazy.load(c) })
+
+	return c.lazy.pixels, c.lazy.err
+}
+
+// PixelWarnings returns Warnings followed by what lenient decoding had to
+// fix in the pixels of a cel read with PixelModeLazy, loading them first.
+func (c *ChunkCelImage) PixelWarnings() []Warning {
+	if c.lazy == nil {
+		return c.Warnings
+	}
+
+	c.LoadPixels()
+
+	return append(c.Warnings[:len(c.Warnings):len(c.Warnings)], c.lazy.warnings...)
+}
+
+// withPixels returns a copy of the cel holding pixels.
+func (c *ChunkCelImage) withPixels(pixels Pixels) *ChunkCelImage {
+	out := *c
+	out.Pixels, out.lazy = pixels, nil
+	return &out
+}
+
+func (p *lazyPixels) load(c *ChunkCelImage) {
+	if p.skipped {
+		p.err = fmt.Errorf("cel: %w", ErrSkippedPixels)
+		return
+	}
+
+	options := p.options
+	options.Pixels = PixelModeEager
+	l := &Loader{
+		Buffer:  bytes.NewBuffer(p.data),
+		File:    &AsepriteFile{Header: p.header},
+		Options: options,
+		colors:  p.palette,
+		budget:  p.budget,
+	}
+
+	chunk, err := l.parseCelImage(c.header, c.ChunkCelData, c.ChunkCelDimensionData, p.compressed, len(p.data), p.frame)
+	if err != nil {
+		p.err = err
+		return
+	}
+
+	p.pixels, p.warnings, p.data = chunk.Pixels, chunk.Warnings, nil
 }
 
 func (c *ChunkCelImage) GetHeader() ChunkHeader {
@@ -567,39 +710,50 @@ type ChunkCelData struct {
 	_          [5]byte
 }
 
+// Pixels holds the pixels of a cel: an *image.NRGBA, a *GrayAlpha or an
+// *image.Paletted depending on the color depth. It is nil for cels read
+// with PixelModeLazy or PixelModeSkip, see ChunkCelImage.LoadPixels.
 type Pixels any
 
 type PixelsCompressed interface {
 	Decompress() (Pixels, error)
 }
 
+// PixelsIndexed held the pixels of indexed cels.
+//
+// Deprecated: cels hold an *image.Paletted now, see Pixels.
 type PixelsIndexed []byte
+
+// PixelsGrayscale held the pixels of grayscale cels.
+//
+// Deprecated: cels hold a *GrayAlpha now, see Pixels.
 type PixelsGrayscale [][2]byte
+
+// PixelsRGBA held the pixels of RGBA cels.
+//
+// Deprecated: cels hold an *image.NRGBA now, see Pixels.
 type PixelsRGBA [][4]byte
+
 type PixelsZlib []byte
 
 func (p *PixelsZlib) Decompress() ([]byte, error) {
-	buffer := bytes.NewBuffer(*p)
-	r, err := zlib.NewReader(buffer)
-	if err != nil {
-		return nil, err
-	}
-
-	defer r.Close()
+	return p.DecompressLimit(DefaultMaxDecompressedBytes)
+}
 
-	d := new(bytes.Buffer)
+// DecompressLimit inflates the payload and fails with a *LimitError as soon
+// as the output grows past limit bytes. A negative limit disables the check.
+func (p *PixelsZlib) DecompressLimit(limit int64) ([]byte, error) {
+	return new(inflater).inflate(*p, newBudget(limit), 0)
+}
 
-	if _, err := io.Copy(d, r); err != nil {
-		return nil, err
+func (p *PixelsRGBA) ToImage(celX, celY, width, height, canvasWidth, canvasHeight int) (image.Image, error) {
+	pixels := *p
+	if width < 0 || height < 0 || len(pixels) < width*height {
+		return nil, fmt.Errorf("image: %w (got %d pixels, want %dx%d)", ErrInvalidPixels, len(pixels), width, height)
 	}
 
-	return d.Bytes(), nil
-}
-
-func (p *PixelsRGBA) ToImage(celX, celY, width, height, canvasWidth, canvasHeight int) image.Image {
 	rect := image.Rect(0, 0, canvasWidth, canvasHeight)
 	img := image.NewRGBA(rect)
-	pixels := *p
 	for y := range height {
 		for x := range width {
 			i := y*width + x
@@ -613,7 +767,7 @@ func (p *PixelsRGBA) ToImage(celX, celY, width, height, canvasWidth, canvasHeigh
 		}
 	}
 
-	return img
+	return img, nil
 }
 
 const ChunkCelDimensionSize = 4
@@ -653,9 +807,9 @@ type ChunkCelCompressedTilemapStaticData struct {
 type ChunkCelCompressedTilemapData struct {
 	ChunkCelDimensionData
 	ChunkCelCompressedTilemapStaticData
-	Tiles PixelsCompressed
-	// NOTE: não sei como fazer esse negocio, voltar depois
-	// NOTE: dica pro lerdinho acima: tile não é pixel !
+	// Tiles holds Width*Height tile values, row by row. Use the masks to
+	// split each one into tile id and flip flags.
+	Tiles []uint32
 }
 
 const UserDataFlagSize = 4
@@ -672,7 +826,7 @@ type ChunkUserData struct {
 	header ChunkHeader
 	Text   string
 	Color  *ChunkUserDataColor
-	Maps   *[]ChunkUserDataPropMap
+	Maps   []ChunkUserDataPropMap
 }
 
 func (c *ChunkUserData) GetHeader() ChunkHeader {
@@ -684,8 +838,28 @@ func (c *ChunkUserData) GetType() ChunkDataType {
 }
 
 type ChunkUserDataPropMap struct {
+	// Key is 0 for the user's own properties, otherwise the ID of the
+	// extension entry in the external files chunk that owns the map.
+	Key      uint32
 	External bool
-	Props    map[string]any
+	Props    Props
+}
+
+// Properties returns the user's own properties map, or nil if there is none.
+func (c *ChunkUserData) Properties() Props {
+	return c.ExtensionProperties(0)
+}
+
+// ExtensionProperties returns the properties map stored by the extension
+// with the given external file ID, or nil if there is none.
+func (c *ChunkUserData) ExtensionProperties(key uint32) Props {
+	for _, m := range c.Maps {
+		if m.Key == key {
+			return m.Props
+		}
+	}
+
+	return nil
 }
 
 type ChunkUserDataTextSize uint32
@@ -747,19 +921,191 @@ func checkMagicNumber(magic, number uint16, from string) error {
 }
 
 func (l *Loader) readToBuffer() error {
-	_, err := l.Reader.Read(l.Buf)
-	if err != nil {
+	if l.Reader == nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	n, err := l.Reader.Read(l.Buf)
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
 		return err
 	}
 
-	_, err = l.Buffer.Write(l.Buf)
+	_, err = l.Buffer.Write(l.Buf[:n])
 	if err != nil {
 		return err
 	}
+	l.read += int64(n)
+
+	return nil
+}
+
+// maxReadStep bounds a single read of fill, so a chunk declaring more data
+// than the file holds cannot make the buffer grow past what is really read.
+const maxReadStep = 64 << 10
+
+// fill reads until size bytes are buffered. Reads of up to len(Buf) go
+// through Buf, larger ones straight into Buffer without reading ahead.
+func (l *Loader) fill(size int) error {
+	for !l.enoughSpaceToRead(size) {
+		need := size - l.Buffer.Len()
+		if need <= len(l.Buf) {
+			if err := l.readToBuffer(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if l.Reader == nil {
+			return io.ErrUnexpectedEOF
+		}
+
+		step := min(need, maxReadStep)
+		l.Buffer.Grow(step)
+		p := l.Buffer.AvailableBuffer()[:step]
+		n, err := io.ReadFull(l.Reader, p)
+		l.Buffer.Write(p[:n])
+		l.read += int64(n)
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// context returns the context of the loader, context.Background when it has
+// none.
+func (l *Loader) context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
+	}
+
+	return l.ctx
+}
+
+// done returns the error of the loader's context once it is done.
+func (l *Loader) done() error {
+	if l.ctx == nil {
+		return nil
+	}
+
+	return l.ctx.Err()
+}
+
+// offset returns how many bytes were consumed from the reader so far.
+func (l *Loader) offset() int64 {
+	return l.read - int64(l.Buffer.Len())
+}
+
+// skip discards n bytes, first from what is already buffered.
+func (l *Loader) skip(n int64) error {
+	buffered := min(n, int64(l.Buffer.Len()))
+	l.Buffer.Next(int(buffered))
+	n -= buffered
+	if n == 0 {
+		return nil
+	}
+
+	if l.Reader == nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	if seeker, ok := l.Reader.(io.Seeker); ok {
+		if _, err := seeker.Seek(n, io.SeekCurrent); err != nil {
+			return err
+		}
+		l.read += n
+		return nil
+	}
+
+	skipped, err := io.CopyN(io.Discard, l.Reader, n)
+	l.read += skipped
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// warn records a tolerated quirk, or turns it into an error in strict mode.
+func (l *Loader) warn(w Warning, err error) error {
+	if l.Options.Strict {
+		return fmt.Errorf("frame %d: %w: %s", w.Frame, err, w.Message)
+	}
+
+	if l.File != nil {
+		l.File.Warnings = append(l.File.Warnings, w)
+	}
+
+	return nil
+}
+
+// syncTo moves the loader to end, the offset where the current chunk or
+// frame should finish according to its declared size.
+func (l *Loader) syncTo(end int64, what string, frameId int, typ ChunkDataType) error {
+	pos := l.offset()
+	switch {
+	case pos < end:
+		w := Warning{Frame: frameId, Chunk: typ, Message: fmt.Sprintf("%s has %d unread bytes", what, end-pos)}
+		if err := l.warn(w, ErrSizeMismatch); err != nil {
+			return err
+		}
+		return l.skip(end - pos)
+	case pos > end:
+		w := Warning{Frame: frameId, Chunk: typ, Message: fmt.Sprintf("%s read %d bytes past its end", what, pos-end)}
+		return l.warn(w, ErrSizeMismatch)
+	}
+
+	return nil
+}
+
+// checkChunkFits reports an error when a length declared inside a chunk can
+// not fit in the chunk itself, so we never allocate based on a bogus count.
+func checkChunkFits(ch ChunkHeader, n uint64, from string) error {
+	if n > uint64(ch.Size) {
+		return fmt.Errorf("%s: %w (declares %d bytes in a %d bytes chunk)", from, ErrInvalidChunkSize, n, ch.Size)
+	}
 
 	return nil
 }
 
+// decompress inflates p within what is left of the file's decompression
+// budget, size being the expected size of the data.
+func (l *Loader) decompress(p PixelsZlib, size int) ([]byte, error) {
+	if l.inflater == nil {
+		l.inflater = new(inflater)
+	}
+
+	return l.inflater.inflate(p, l.decompressBudget(), size)
+}
+
+// decompressBudget returns the decompression budget of the file, created on
+// first use.
+func (l *Loader) decompressBudget() *budget {
+	if l.budget == nil {
+		l.budget = newBudget(limitOrDefault(l.Options.MaxDecompressedBytes, DefaultMaxDecompressedBytes))
+	}
+
+	return l.budget
+}
+
+// enterUserData tracks how deep nested user data values go; every call must
+// be paired with leaveUserData.
+func (l *Loader) enterUserData() error {
+	l.userDataDepth++
+	return checkLimit("MaxUserDataDepth", l.userDataDepth, limitOrDefault(l.Options.MaxUserDataDepth, DefaultMaxUserDataDepth))
+}
+
+func (l *Loader) leaveUserData() {
+	l.userDataDepth--
+}
+
 func (l *Loader) enoughSpaceToRead(size int) bool {
 	available := l.Buffer.Len()
 	needed := size
@@ -769,60 +1115,54 @@ func (l *Loader) enoughSpaceToRead(size int) bool {
 // NOTE: DEPRECATED: use v2
 func BytesToStruct[T any](loader *Loader, size int) (T, error) {
 	var t T
-	if loader.enoughSpaceToRead(size) {
-		err := binary.Read(loader.Buffer, binary.LittleEndian, &t)
-		if err != nil {
-			return t, err
-		}
-		return t, nil
-	}
-
-	err := loader.readToBuffer()
-	if err != nil {
+	if err := loader.fill(size); err != nil {
 		return t, err
 	}
 
-	return BytesToStruct[T](loader, size)
+	err := binary.Read(loader.Buffer, binary.LittleEndian, &t)
+	return t, err
 }
 
 func (l *Loader) BytesToStructV2(size int, t any) error {
-	if l.enoughSpaceToRead(size) {
-		err := binary.Read(l.Buffer, binary.LittleEndian, t)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-
-	err := l.readToBuffer()
-	if err != nil {
+	if err := l.fill(size); err != nil {
 		return err
 	}
 
-	return l.BytesToStructV2(size, t)
+	return binary.Read(l.Buffer, binary.LittleEndian, t)
 }
 
+// loadFrameChunkData returns the body of a chunk without copying it out of
+// the buffer, so it is only valid until the loader reads again.
 func (l *Loader) loadFrameChunkData(ch ChunkHeader) ([]byte, error) {
-	size := ch.Size - ChunkHeaderSize
-	if l.enoughSpaceToRead(int(size)) {
-		bufchunk := make([]byte, size)
-		_, err := io.ReadFull(l.Buffer, bufchunk)
-		if err != nil {
-			return nil, err
-		}
-		return bufchunk, nil
+	if ch.Size < ChunkHeaderSize {
+		return nil, fmt.Errorf("chunk: %w (got %d)", ErrInvalidChunkSize, ch.Size)
 	}
 
-	err := l.readToBuffer()
-	if err != nil {
+	size := int(ch.Size - ChunkHeaderSize)
+	if err := l.fill(size); err != nil {
 		return nil, err
 	}
 
-	return l.loadFrameChunkData(ch)
+	return l.Buffer.Next(size), nil
+}
+
+// readBytes buffers size bytes before allocating them, so a bogus declared
+// size ends in an EOF error instead of a huge allocation.
+func (l *Loader) readBytes(size int) ([]byte, error) {
+	if err := l.fill(size); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(l.Buffer, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
 }
 
 func (l *Loader) ParseHeader() (Header, error) {
-	header, err := BytesToStruct[Header](l, ChunkHeaderSize)
+	header, err := BytesToStruct[Header](l, HeaderSize)
 	if err != nil {
 		return header, err
 	}
@@ -836,8 +1176,6 @@ func (l *Loader) ParseHeader() (Header, error) {
 }
 
 func (l *Loader) ParseChunk(ch ChunkHeader, frameId int) (Chunk, error) {
-	var chunk Chunk
-
 	switch ch.Type {
 	case ColorProfileChunkHex:
 		return l.ParseChunkColorProfile(ch)
@@ -864,16 +1202,125 @@ func (l *Loader) ParseChunk(ch ChunkHeader, frameId int) (Chunk, error) {
 	case UserDataChunkHex:
 		return l.ParseChunkUserData(ch)
 	case MaskChunkHex:
-		l.loadFrameChunkData(ch)
-		return chunk, nil
+		_, err := l.loadFrameChunkData(ch)
+		return nil, err
 	case PathChunkHex:
-		l.loadFrameChunkData(ch)
-		return chunk, nil
+		_, err := l.loadFrameChunkData(ch)
+		return nil, err
 	default:
-		panic("unreachable: Invalid chunk type: " + fmt.Sprint(ch.Type))
+		return nil, fmt.Errorf("chunk: %w 0x%X", ErrInvalidChunkType, ch.Type)
 	}
 }
 
+// ParseBoundedChunk parses a chunk without letting it read past its declared
+// size, and always leaves the loader at the start of the next chunk. A chunk
+// whose content is shorter or longer than its size is an error in strict
+// mode; lenient mode warns and, when the content runs past the end, drops
+// the chunk.
+func (l *Loader) ParseBoundedChunk(ch ChunkHeader, frameId int) (Chunk, error) {
+	body, err := l.loadFrameChunkData(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	// the loader does not touch its own buffer before body is parsed
+	reader, buffer, read := l.Reader, l.Buffer, l.read
+	l.Reader, l.Buffer = nil, bytes.NewBuffer(body)
+	defer func() {
+		l.Reader, l.Buffer, l.read = reader, buffer, read
+	}()
+
+	c, err := l.ParseChunk(ch, frameId)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		w := Warning{Frame: frameId, Chunk: ch.Type, Message: fmt.Sprintf("chunk content runs past its size of %d bytes", ch.Size)}
+		return nil, l.warn(w, ErrSizeMismatch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if unread := l.Buffer.Len(); unread > 0 {
+		w := Warning{Frame: frameId, Chunk: ch.Type, Message: fmt.Sprintf("chunk has %d unread bytes", unread)}
+		if err := l.warn(w, ErrSizeMismatch); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// skipCelChunk parses a cel chunk with PixelModeSkip. Only the fields in
+// front of the pixel data are read, the rest is skipped without being
+// buffered. Chunks too small to hold every field go through
+// ParseBoundedChunk.
+func (l *Loader) skipCelChunk(ch ChunkHeader, frameId int) (Chunk, error) {
+	if ch.Size < ChunkHeaderSize+ChunkCelDataSize+ChunkCelDimensionSize+ChunkCelCompressedTilemapStaticDataSize {
+		return l.ParseBoundedChunk(ch, frameId)
+	}
+
+	end := l.offset() + int64(ch.Size) - ChunkHeaderSize
+	c, err := l.ParseChunkCel(ch, frameId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.syncTo(end, "chunk", frameId, ch.Type); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// skipTilesetChunk parses a tileset chunk with PixelModeSkip. The fields in
+// front of the tileset image are peeked to find where the image ends, then
+// read from the stream like skipCelChunk does, so the image is skipped
+// without being buffered. Chunks whose fields don't fit go through
+// ParseBoundedChunk.
+func (l *Loader) skipTilesetChunk(ch ChunkHeader, frameId int) (Chunk, error) {
+	size := int64(ch.Size) - ChunkHeaderSize
+	if size < ChunkTilesetDataSize {
+		return l.ParseBoundedChunk(ch, frameId)
+	}
+
+	if err := l.fill(ChunkTilesetDataSize); err != nil {
+		return nil, err
+	}
+
+	var tilesetData ChunkTilesetData
+	if err := binary.Read(bytes.NewReader(l.Buffer.Bytes()), binary.LittleEndian, &tilesetData); err != nil {
+		return nil, err
+	}
+
+	prefix := int64(ChunkTilesetDataSize) + int64(tilesetData.NameLength) + 4
+	if tilesetData.FlagsBit&1 != 0 {
+		prefix += ChunkTilesetLinkExternalFileDataSize
+	}
+	if tilesetData.FlagsBit&2 == 0 || prefix > size {
+		return l.ParseBoundedChunk(ch, frameId)
+	}
+
+	if err := l.fill(int(prefix)); err != nil {
+		return nil, err
+	}
+
+	pixelDataSize := int64(binary.LittleEndian.Uint32(l.Buffer.Bytes()[prefix-4:]))
+	if prefix+pixelDataSize > size {
+		return l.ParseBoundedChunk(ch, frameId)
+	}
+
+	end := l.offset() + size
+	c, err := l.ParseChunkTileset(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.syncTo(end, "chunk", frameId, ch.Type); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
 func (l *Loader) ParseChunkTileset(ch ChunkHeader) (Chunk, error) {
 	var tilesetData ChunkTilesetData
 	if err := l.BytesToStructV2(ChunkTilesetDataSize, &tilesetData); err != nil {
@@ -920,38 +1367,51 @@ func (l *Loader) ParseChunkTileset(ch ChunkHeader) (Chunk, error) {
 			return nil, err
 		}
 
-		pixelsCompressed := make(PixelsZlib, pixelDataSize)
-		if err := l.BytesToStructV2(int(pixelDataSize), &pixelsCompressed); err != nil {
+		if err := checkChunkFits(ch, uint64(pixelDataSize), "tileset"); err != nil {
 			return nil, err
 		}
 
-		buffer := bytes.NewBuffer(pixelsCompressed)
-		r, err := zlib.NewReader(buffer)
-		if err != nil {
-			return nil, err
+		if err := l.Options.checkImage(int64(tilesetData.TileWidth), int64(tilesetData.TileHeight)*int64(tilesetData.TilesNumber)); err != nil {
+			return nil, fmt.Errorf("tileset: %w", err)
 		}
 
-		defer r.Close()
+		if l.Options.Pixels == PixelModeSkip {
+			return &chunk, l.skip(int64(pixelDataSize))
+		}
 
-		d := new(bytes.Buffer)
+		// tileset images were always read as RGBA, keep doing so when there
+		// is no header to tell the color depth
+		depth := ColorDepthRGBA
+		if l.File != nil {
+			depth = l.File.Header.ColorDepth
+		}
 
-		if _, err := io.Copy(d, r); err != nil {
+		// tilesets are only found in the first frame
+		width, height := int(tilesetData.TileWidth), int(tilesetData.TileHeight)*int(tilesetData.TilesNumber)
+		d, err := l.readPixelData(true, int(pixelDataSize), width*height*depth.BytesPerPixel())
+		if err != nil {
 			return nil, err
 		}
 
-		bytesTo4ByteChunks := func(data []byte) [][4]byte {
-			var chunks [][4]byte
-			for i := 0; i < len(data); i += 4 {
-				var block [4]byte
-				copy(block[:], data[i:i+4])
-				chunks = append(chunks, block)
-			}
-			return chunks
+		var palette color.Palette
+		if depth == ColorDepthIndexed {
+			palette = l.imagePalette()
 		}
 
-		t := bytesTo4ByteChunks(d.Bytes())
+		d, warning, err := l.fitImageData(d, depth, width, height, "tileset", Warning{Chunk: TilesetChunkHex})
+		if err != nil {
+			return nil, err
+		}
+		if warning != nil {
+			if err := l.warn(*warning, ErrInvalidPixels); err != nil {
+				return nil, err
+			}
+		}
 
-		tilesetImage := Pixels(t)
+		tilesetImage, err := newPixels(d, depth, width, height, palette)
+		if err != nil {
+			return nil, fmt.Errorf("tileset: %w", err)
+		}
 
 		chunk.TilesetImage = &tilesetImage
 	}
@@ -970,6 +1430,10 @@ func (l *Loader) ParseChunkSlice(ch ChunkHeader) (Chunk, error) {
 		return nil, err
 	}
 
+	if err := checkChunkFits(ch, uint64(chunkSliceData.NumberSliceKeys)*ChunkSliceKeyDataSize, "slice"); err != nil {
+		return nil, err
+	}
+
 	sliceKeys := make([]ChunkSliceKey, chunkSliceData.NumberSliceKeys)
 	for i := 0; i < int(chunkSliceData.NumberSliceKeys); i++ {
 		var sliceKeyData ChunkSliceKeyData
@@ -1098,32 +1562,26 @@ func initReaders() {
 		},
 
 		UserDataPoint: func(l *Loader) (any, error) {
-			var p struct {
-				X int32 // LONG
-				Y int32 // LONG
-			}
+			var p PropPoint // LONG, LONG
 			return p, l.BytesToStructV2(8, &p)
 		},
 
 		UserDataSize: func(l *Loader) (any, error) {
-			var s struct {
-				W int32 // LONG
-				H int32 // LONG
-			}
+			var s PropSize // LONG, LONG
 			return s, l.BytesToStructV2(8, &s)
 		},
 
 		UserDataRect: func(l *Loader) (any, error) {
-			var r struct {
-				X int32
-				Y int32
-				W int32
-				H int32
-			}
+			var r PropRect // POINT, SIZE
 			return r, l.BytesToStructV2(16, &r)
 		},
 
 		UserDataVector: func(l *Loader) (any, error) {
+			if err := l.enterUserData(); err != nil {
+				return nil, err
+			}
+			defer l.leaveUserData()
+
 			var count uint32 // DWORD
 			if err := l.BytesToStructV2(4, &count); err != nil {
 				return nil, err
@@ -1177,6 +1635,11 @@ func initReaders() {
 		},
 
 		UserDataProp: func(l *Loader) (any, error) {
+			if err := l.enterUserData(); err != nil {
+				return nil, err
+			}
+			defer l.leaveUserData()
+
 			var propsLen uint32
 			if err := l.BytesToStructV2(4, &propsLen); err != nil {
 				return nil, err
@@ -1187,8 +1650,8 @@ func initReaders() {
 	}
 }
 
-func (l *Loader) ParseUserDataProps(count int) (map[string]any, error) {
-	props := make(map[string]any, count)
+func (l *Loader) ParseUserDataProps(count int) (Props, error) {
+	props := make(Props)
 	for range count {
 		var nameLen uint16
 		if err := l.BytesToStructV2(2, &nameLen); err != nil {
@@ -1223,11 +1686,12 @@ func (l *Loader) ParseUserDataProps(count int) (map[string]any, error) {
 
 func (l *Loader) ParseUserDataPropMap() (*ChunkUserDataPropMap, error) {
 	var propMapData ChunkUserDataPropMapData
-	if err := l.BytesToStructV2(ChunkUserDataPropMapHeaderSize, &propMapData); err != nil {
+	if err := l.BytesToStructV2(ChunkUserDataPropMapDataSize, &propMapData); err != nil {
 		return nil, err
 	}
 
 	propMap := &ChunkUserDataPropMap{
+		Key:      propMapData.PropKey,
 		External: (propMapData.PropKey != 0),
 	}
 
@@ -1280,12 +1744,21 @@ func (l *Loader) ParseChunkUserData(ch ChunkHeader) (Chunk, error) {
 			return nil, err
 		}
 
+		if err := checkChunkFits(ch, uint64(mapHeader.PropMapNumbers)*ChunkUserDataPropMapDataSize, "userdata"); err != nil {
+			return nil, err
+		}
+
 		propMaps := make([]ChunkUserDataPropMap, mapHeader.PropMapNumbers)
-		for range mapHeader.PropMapNumbers {
-			l.ParseUserDataPropMap()
+		for i := range propMaps {
+			propMap, err := l.ParseUserDataPropMap()
+			if err != nil {
+				return nil, err
+			}
+
+			propMaps[i] = *propMap
 		}
 
-		chunk.Maps = &propMaps
+		chunk.Maps = propMaps
 	}
 
 	return chunk, nil
@@ -1297,6 +1770,10 @@ func (l *Loader) ParseChunkExternalFiles(ch ChunkHeader) (Chunk, error) {
 		return nil, err
 	}
 
+	if err := checkChunkFits(ch, uint64(externalFilesData.NumberEntries)*ChunkExternalFilesEntryDataSize, "externalfiles"); err != nil {
+		return nil, err
+	}
+
 	entries := make([]ChunkExternalFilesEntry, externalFilesData.NumberEntries)
 	for i := 0; i < int(externalFilesData.NumberEntries); i++ {
 		var entryData ChunkExternalFilesEntryData
@@ -1371,7 +1848,7 @@ func (l *Loader) ParseChunkLayer(ch ChunkHeader) (Chunk, error) {
 
 	chunk.ChunkLayerFlags = flags
 
-	if flags.LockMovement {
+	if l.File != nil && l.File.Header.Flags&HeaderFlagLayersUUID != 0 {
 		var lockData ChunkLayerLockMovementData
 		if err := l.BytesToStructV2(16, &lockData); err != nil {
 			return nil, err
@@ -1389,39 +1866,56 @@ func (l *Loader) ParseChunkOldPalette(ch ChunkHeader) (Chunk, error) {
 		return nil, err
 	}
 
-	colors := make([]ChunkOldPaletteColor, 256)
-	for i := range packetsNumber {
-		var paletteEntriesNumber byte
-		if err := l.BytesToStructV2(1, &paletteEntriesNumber); err != nil {
+	colors := make([]ChunkOldPaletteColor, 0, 256)
+	packets := make([]ChunkOldPalettePacket, 0)
+	index := 0
+	for range packetsNumber {
+		var packet ChunkOldPalettePacket
+		if err := l.BytesToStructV2(2, &packet); err != nil {
 			return nil, err
 		}
+		packets = append(packets, packet)
 
-		var colorsNumber byte
-		if err := l.BytesToStructV2(1, &colorsNumber); err != nil {
-			return nil, err
+		// the skip counts from the end of the previous packet
+		index += int(packet.PaletteEntriesNumber)
+
+		colorsNumber := int(packet.ColorsNumber)
+		if colorsNumber == 0 {
+			colorsNumber = 256
+		}
+
+		if index+colorsNumber > 256 {
+			return nil, fmt.Errorf("oldpalette: color index %d out of range", index+colorsNumber-1)
+		}
+
+		if index+colorsNumber > len(colors) {
+			colors = colors[:index+colorsNumber]
 		}
-		i += uint16(paletteEntriesNumber)
+
 		for range colorsNumber {
 			var color ChunkOldPaletteColor
 			if err := l.BytesToStructV2(3, &color); err != nil {
 				return nil, err
 			}
 
-			colors[i] = color
+			colors[index] = color
+			index++
 		}
 	}
 
 	switch ch.Type {
 	case OldPaletteChunkHex:
 		return &ChunkOldPalette{
-			header: ch,
-			Colors: colors,
+			header:  ch,
+			Colors:  colors,
+			Packets: packets,
 		}, nil
 	case OldPaletteChunk2Hex:
 		return &ChunkOldPalette2{
 			ChunkOldPalette: &ChunkOldPalette{
-				header: ch,
-				Colors: colors,
+				header:  ch,
+				Colors:  colors,
+				Packets: packets,
 			},
 		}, nil
 	default:
@@ -1430,65 +1924,293 @@ func (l *Loader) ParseChunkOldPalette(ch ChunkHeader) (Chunk, error) {
 	}
 }
 
-func BytesToPixelsRGBA(data []byte) PixelsRGBA {
-	var chunks [][4]byte
-	for i := 0; i < len(data); i += 4 {
-		var block [4]byte
-		copy(block[:], data[i:i+4])
-		chunks = append(chunks, block)
+// BytesToPixelsRGBA splits RGBA pixel data into pixels.
+//
+// Deprecated: cels hold an *image.NRGBA now, see ResolvePixelType.
+func BytesToPixelsRGBA(data []byte) (PixelsRGBA, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("rgba: %w (%d bytes is not a multiple of 4)", ErrInvalidPixels, len(data))
 	}
-	return chunks
+
+	chunks := make(PixelsRGBA, len(data)/4)
+	for i := range chunks {
+		copy(chunks[i][:], data[i*4:])
+	}
+	return chunks, nil
 }
 
-func BytesToPixelsGrayscale(data []byte) PixelsGrayscale {
-	var chunks [][2]byte
-	for i := 0; i < len(data); i += 2 {
-		var block [2]byte
-		copy(block[:], data[i:i+2])
-		chunks = append(chunks, block)
+// BytesToPixelsGrayscale splits grayscale pixel data into pixels.
+//
+// Deprecated: cels hold a *GrayAlpha now, see ResolvePixelType.
+func BytesToPixelsGrayscale(data []byte) (PixelsGrayscale, error) {
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("grayscale: %w (%d bytes is not a multiple of 2)", ErrInvalidPixels, len(data))
+	}
+
+	chunks := make(PixelsGrayscale, len(data)/2)
+	for i := range chunks {
+		copy(chunks[i][:], data[i*2:])
 	}
-	return chunks
+	return chunks, nil
 }
 
-func (l *Loader) ResolvePixelType(buf []byte) Pixels {
-	var pixels Pixels
-	colorDepth := l.File.Header.ColorDepth
+// ResolvePixelType wraps buf, the pixel data of a width x height image, in
+// the image type of the file's color depth without copying it: an
+// *image.NRGBA for RGBA, a *GrayAlpha for grayscale and an *image.Paletted
+// for indexed files. The palette of an *image.Paletted is the palette in
+// effect when the cel was read, with the transparent index made
+// transparent.
+func (l *Loader) ResolvePixelType(buf []byte, width, height int) (Pixels, error) {
+	if l.File == nil {
+		return nil, fmt.Errorf("pixels: %w (no file header)", ErrInvalidColorDepth)
+	}
 
-	switch colorDepth {
-	case ColorDepthRGBA:
-		pixels = PixelsRGBA(BytesToPixelsRGBA(buf))
-	case ColorDepthGrayscale:
-		pixels = PixelsGrayscale(BytesToPixelsGrayscale(buf))
-	case ColorDepthIndexed:
-		pixels = buf
-	default:
-		panic("unreachable: colordepth possibly not defined: " + fmt.Sprint(colorDepth))
+	depth := l.File.Header.ColorDepth
+
+	var palette color.Palette
+	if depth == ColorDepthIndexed {
+		palette = l.imagePalette()
 	}
 
-	return pixels
+	return newPixels(buf, depth, width, height, palette)
 }
 
-func (l *Loader) GetPixels(ch ChunkHeader, compressed bool, pixelDataSize int) (Pixels, error) {
-	var pbuf []byte
-	if compressed {
-		pixelsCompressed := make(PixelsZlib, pixelDataSize)
-		if err := l.BytesToStructV2(pixelDataSize, &pixelsCompressed); err != nil {
-			return nil, err
+// imagePalette returns the palette indexed images are wrapped with.
+func (l *Loader) imagePalette() color.Palette {
+	if l.colors == nil {
+		transparent := -1
+		if l.File != nil {
+			transparent = int(l.File.Header.PaletteEntry)
 		}
+		l.colors = imagePalette(l.palette, transparent)
+	}
 
-		var err error
-		pbuf, err = pixelsCompressed.Decompress()
-		if err != nil {
+	return l.colors
+}
+
+func (l *Loader) GetPixels(ch ChunkHeader, dimensions ChunkCelDimensionData, compressed bool, pixelDataSize int) (Pixels, error) {
+	pbuf, err := l.readPixelData(compressed, pixelDataSize, l.imageSize(int(dimensions.Width), int(dimensions.Height)))
+	if err != nil {
+		return nil, err
+	}
+
+	return l.ResolvePixelType(pbuf, int(dimensions.Width), int(dimensions.Height))
+}
+
+// readPixelData reads pixelDataSize bytes of pixel data, inflating them when
+// compressed. size is the expected size of the data once inflated.
+func (l *Loader) readPixelData(compressed bool, pixelDataSize, size int) ([]byte, error) {
+	if pixelDataSize < 0 {
+		return nil, fmt.Errorf("pixels: %w (got %d)", ErrInvalidChunkSize, pixelDataSize)
+	}
+
+	if !compressed {
+		return l.readBytes(pixelDataSize)
+	}
+
+	// compressed data is only needed until it is inflated, it is read in
+	// place instead of being copied out of the buffer
+	if err := l.fill(pixelDataSize); err != nil {
+		return nil, err
+	}
+
+	return l.decompress(l.Buffer.Next(pixelDataSize), size)
+}
+
+// imageSize returns the size of the pixel data of a width x height image in
+// the color depth of the file.
+func (l *Loader) imageSize(width, height int) int {
+	if l.File == nil {
+		return 0
+	}
+
+	return width * height * l.File.Header.ColorDepth.BytesPerPixel()
+}
+
+// fitPixelData checks that buf holds exactly one image of the given
+// dimensions. Strict mode rejects a mismatch, lenient mode pads with
+// transparent pixels or drops the excess and returns a warning.
+func (l *Loader) fitPixelData(buf []byte, dimensions ChunkCelDimensionData, frameId int) ([]byte, *Warning, error) {
+	if l.File == nil {
+		return nil, nil, fmt.Errorf("cel: %w (no file header)", ErrInvalidColorDepth)
+	}
+
+	w := Warning{Frame: frameId, Chunk: CelChunkHex}
+	return l.fitImageData(buf, l.File.Header.ColorDepth, int(dimensions.Width), int(dimensions.Height), "cel", w)
+}
+
+// maxPaddedFraction bounds the padding of short image data: lenient mode
+// pads it up to the declared size when at most 1/maxPaddedFraction of it is
+// missing.
+const maxPaddedFraction = 4
+
+// fitImageData is fitPixelData for any image of what, w being the warning
+// to fill in.
+func (l *Loader) fitImageData(buf []byte, depth ColorDepth, width, height int, what string, w Warning) ([]byte, *Warning, error) {
+	bpp := depth.BytesPerPixel()
+	if bpp == 0 {
+		return nil, nil, fmt.Errorf("%s: %w %d", what, ErrInvalidColorDepth, depth)
+	}
+
+	want := width * height * bpp
+	if len(buf) == want {
+		return buf, nil, nil
+	}
+
+	if l.Options.Strict {
+		return nil, nil, fmt.Errorf("%s: %w (got %d bytes, want %d for %dx%d)", what, ErrInvalidPixels, len(buf), want, width, height)
+	}
+
+	w.Message = fmt.Sprintf("%s has %d bytes of pixel data, want %d for %dx%d", what, len(buf), want, width, height)
+
+	if len(buf) > want {
+		return buf[:want], &w, nil
+	}
+
+	// a few missing rows are padded, but a cel missing most of its data
+	// would let a tiny file claim the memory of a huge image
+	if want-len(buf) > want/maxPaddedFraction {
+		return nil, nil, fmt.Errorf("%s: %w (got %d bytes, want %d for %dx%d)", what, ErrInvalidPixels, len(buf), want, width, height)
+	}
+
+	// padding is allocated from the declared size, so it counts against the
+	// decompression budget like inflated data does
+	if err := l.decompressBudget().reserve(int64(want - len(buf))); err != nil {
+		return nil, nil, err
+	}
+
+	padded := make([]byte, want)
+	copy(padded, buf)
+	if depth == ColorDepthIndexed && l.File != nil {
+		for i := len(buf); i < want; i++ {
+			padded[i] = l.File.Header.PaletteEntry
+		}
+	}
+
+	return padded, &w, nil
+}
+
+func (l *Loader) readTiles(bitsPerTile uint16, dimensions ChunkCelDimensionData, dataSize, frameId int) ([]uint32, error) {
+	if dataSize < 0 {
+		return nil, fmt.Errorf("tilemap: %w (got %d)", ErrInvalidChunkSize, dataSize)
+	}
+
+	if bitsPerTile != 8 && bitsPerTile != 16 && bitsPerTile != 32 {
+		return nil, fmt.Errorf("tilemap: %w (%d bits per tile)", ErrInvalidPixels, bitsPerTile)
+	}
+
+	bytesPerTile := int(bitsPerTile / 8)
+	count := int(dimensions.Width) * int(dimensions.Height)
+	data, err := l.readPixelData(true, dataSize, count*bytesPerTile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != count*bytesPerTile {
+		w := Warning{Frame: frameId, Chunk: CelChunkHex, Message: fmt.Sprintf("tilemap has %d bytes of tiles, want %d for %dx%d", len(data), count*bytesPerTile, dimensions.Width, dimensions.Height)}
+		if err := l.warn(w, ErrInvalidPixels); err != nil {
 			return nil, err
 		}
-	} else {
-		pbuf = make([]byte, pixelDataSize)
-		if err := l.BytesToStructV2(pixelDataSize, &pbuf); err != nil {
+		count = min(count, len(data)/bytesPerTile)
+	}
+
+	tiles := make([]uint32, count)
+	for i := range tiles {
+		switch bytesPerTile {
+		case 1:
+			tiles[i] = uint32(data[i])
+		case 2:
+			tiles[i] = uint32(binary.LittleEndian.Uint16(data[i*2:]))
+		case 4:
+			tiles[i] = binary.LittleEndian.Uint32(data[i*4:])
+		}
+	}
+
+	return tiles, nil
+}
+
+func (l *Loader) parseCelImage(ch ChunkHeader, cData ChunkCelData, dimensions ChunkCelDimensionData, compressed bool, pixelDataSize, frameId int) (*ChunkCelImage, error) {
+	if l.Options.Pixels != PixelModeEager {
+		return l.deferCelImage(ch, cData, dimensions, compressed, pixelDataSize, frameId)
+	}
+
+	if l.cels != nil {
+		return l.queueCelImage(ch, cData, dimensions, compressed, pixelDataSize, frameId)
+	}
+
+	pbuf, err := l.readPixelData(compressed, pixelDataSize, l.imageSize(int(dimensions.Width), int(dimensions.Height)))
+	if err != nil {
+		return nil, err
+	}
+
+	pbuf, warning, err := l.fitPixelData(pbuf, dimensions, frameId)
+	if err != nil {
+		return nil, err
+	}
+
+	pixels, err := l.ResolvePixelType(pbuf, int(dimensions.Width), int(dimensions.Height))
+	if err != nil {
+		return nil, err
+	}
+
+	chunk := &ChunkCelImage{
+		header:       ch,
+		ChunkCelData: cData,
+		ChunkCelRawImageData: ChunkCelRawImageData{
+			ChunkCelDimensionData: dimensions,
+			Pixels:                pixels,
+		},
+	}
+
+	if warning != nil {
+		chunk.Warnings = append(chunk.Warnings, *warning)
+		if err := l.warn(*warning, ErrInvalidPixels); err != nil {
 			return nil, err
 		}
 	}
 
-	return l.ResolvePixelType(pbuf), nil
+	return chunk, nil
+}
+
+// deferCelImage keeps the pixel data of a cel for LoadPixels, or skips it.
+func (l *Loader) deferCelImage(ch ChunkHeader, cData ChunkCelData, dimensions ChunkCelDimensionData, compressed bool, pixelDataSize, frameId int) (*ChunkCelImage, error) {
+	if l.File == nil {
+		return nil, fmt.Errorf("cel: %w (no file header)", ErrInvalidColorDepth)
+	}
+
+	lazy := &lazyPixels{
+		header:     l.File.Header,
+		options:    l.Options,
+		frame:      frameId,
+		compressed: compressed,
+		skipped:    l.Options.Pixels == PixelModeSkip,
+		budget:     l.decompressBudget(),
+	}
+	if !lazy.skipped && l.File.Header.ColorDepth == ColorDepthIndexed {
+		lazy.palette = l.imagePalette()
+	}
+
+	chunk := &ChunkCelImage{
+		header:       ch,
+		ChunkCelData: cData,
+		ChunkCelRawImageData: ChunkCelRawImageData{
+			ChunkCelDimensionData: dimensions,
+		},
+		lazy: lazy,
+	}
+
+	if lazy.skipped {
+		return chunk, l.skip(int64(pixelDataSize))
+	}
+
+	data, err := l.readBytes(pixelDataSize)
+	if err != nil {
+		return nil, err
+	}
+
+	lazy.data = data
+
+	return chunk, nil
 }
 
 func (l *Loader) ParseChunkCel(ch ChunkHeader, frameId int) (Chunk, error) {
@@ -1514,50 +2236,43 @@ func (l *Loader) ParseChunkCel(ch ChunkHeader, frameId int) (Chunk, error) {
 		return nil, err
 	}
 
-	pixelDataSize := int(ch.Size - ChunkHeaderSize - ChunkCelDataSize - ChunkCelDimensionSize)
+	if err := l.Options.checkImage(int64(dimensions.Width), int64(dimensions.Height)); err != nil {
+		return nil, fmt.Errorf("cel: %w", err)
+	}
+
+	pixelDataSize := int(ch.Size) - ChunkHeaderSize - ChunkCelDataSize - ChunkCelDimensionSize
+	if pixelDataSize < 0 {
+		return nil, fmt.Errorf("cel: %w (got %d)", ErrInvalidChunkSize, ch.Size)
+	}
+
 	switch cData.CelType {
 	case CelTypeRawImage:
-		var pixels Pixels
-		var err error
-		if pixels, err = l.GetPixels(ch, false, pixelDataSize); err != nil {
-			return nil, err
-		}
-		return &ChunkCelImage{
-			header:       ch,
-			ChunkCelData: cData,
-			ChunkCelRawImageData: ChunkCelRawImageData{
-				ChunkCelDimensionData: dimensions,
-				Pixels:                pixels,
-			},
-		}, nil
+		return l.parseCelImage(ch, cData, dimensions, false, pixelDataSize, frameId)
 	case CelTypeCompressedImage:
-		var pixels Pixels
-		var err error
-		if pixels, err = l.GetPixels(ch, true, pixelDataSize); err != nil {
-			return nil, err
-		}
-
-		// p := pixels.(PixelsRGBA)
-		// p.ToImage(int(cData.X), int(cData.Y), int(dimensions.Width), int(dimensions.Height), int(l.File.Header.Width), int(l.File.Header.Height), frameId)
-
-		return &ChunkCelImage{
-			header:       ch,
-			ChunkCelData: cData,
-			ChunkCelRawImageData: ChunkCelRawImageData{
-				ChunkCelDimensionData: dimensions,
-				Pixels:                pixels,
-			},
-		}, nil
+		return l.parseCelImage(ch, cData, dimensions, true, pixelDataSize, frameId)
 	case CelTypeCompressedTilemap:
-		// TODO: ver dps dado errado @Carto1a
 		var ctilemapStatic ChunkCelCompressedTilemapStaticData
 		if err := l.BytesToStructV2(ChunkCelCompressedTilemapStaticDataSize, &ctilemapStatic); err != nil {
 			return nil, err
 		}
 
+		var tiles []uint32
+		if l.Options.Pixels == PixelModeSkip {
+			if err := l.skip(int64(pixelDataSize - ChunkCelCompressedTilemapStaticDataSize)); err != nil {
+				return nil, err
+			}
+		} else {
+			var err error
+			tiles, err = l.readTiles(ctilemapStatic.BitsPerTile, dimensions, pixelDataSize-ChunkCelCompressedTilemapStaticDataSize, frameId)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		cTilemapData := ChunkCelCompressedTilemapData{
 			ChunkCelDimensionData:               dimensions,
 			ChunkCelCompressedTilemapStaticData: ctilemapStatic,
+			Tiles:                               tiles,
 		}
 		return &ChunkCelTilemap{
 			header:                        ch,
@@ -1566,7 +2281,7 @@ func (l *Loader) ParseChunkCel(ch ChunkHeader, frameId int) (Chunk, error) {
 		}, nil
 	}
 
-	return nil, nil
+	return nil, fmt.Errorf("cel: %w %d", ErrInvalidCelType, cData.CelType)
 }
 
 func (l *Loader) ParseChunkColorProfile(ch ChunkHeader) (Chunk, error) {
@@ -1578,12 +2293,16 @@ func (l *Loader) ParseChunkColorProfile(ch ChunkHeader) (Chunk, error) {
 
 	if cData.Type == ColorProfileICC {
 		var iccSize uint32
-		err = l.BytesToStructV2(8, &iccSize)
+		err = l.BytesToStructV2(4, &iccSize)
 		if err != nil {
 			return nil, err
 		}
-		iccData := make([]byte, iccSize)
-		err = l.BytesToStructV2(int(iccSize), &iccData)
+
+		if err := checkChunkFits(ch, uint64(iccSize), "colorprofile"); err != nil {
+			return nil, err
+		}
+
+		iccData, err := l.readBytes(int(iccSize))
 		if err != nil {
 			return nil, err
 		}
@@ -1613,15 +2332,35 @@ func (l *Loader) ParseChunkPalette(ch ChunkHeader) (Chunk, error) {
 		return nil, err
 	}
 
+	if cData.To < cData.From {
+		return nil, fmt.Errorf("palette: invalid range (from %d to %d)", cData.From, cData.To)
+	}
+
+	// the size and range aren't backed by chunk data, keep them in reach of
+	// a 16-bit index so merging palettes can't allocate without bound
+	if cData.EntriesNumber > MaxPaletteEntries || cData.To >= MaxPaletteEntries {
+		return nil, fmt.Errorf("palette: %w (%d entries, range %d to %d)", ErrInvalidChunkSize, cData.EntriesNumber, cData.From, cData.To)
+	}
+
+	if err := checkChunkFits(ch, (uint64(cData.To)-uint64(cData.From)+1)*ChunkPaletteEntryDataSize, "palette"); err != nil {
+		return nil, err
+	}
+
 	entries := make([]ChunkPaletteEntry, 0)
 	for range cData.To - cData.From + 1 {
-		entry := ChunkPaletteEntry{}
 		var entryData ChunkPaletteEntryData
 		if err := l.BytesToStructV2(ChunkPaletteEntryDataSize, &entryData); err != nil {
 			return nil, err
 		}
 
-		if entryData.HasName == 1 {
+		entry := ChunkPaletteEntry{
+			Red:   entryData.Red,
+			Green: entryData.Green,
+			Blue:  entryData.Blue,
+			Alpha: entryData.Alpha,
+		}
+
+		if entryData.HasName&1 != 0 {
 			var nameLen uint16
 			if err := l.BytesToStructV2(2, &nameLen); err != nil {
 				return nil, err
@@ -1651,6 +2390,10 @@ func (l *Loader) ParseChunkTag(ch ChunkHeader) (Chunk, error) {
 		return nil, err
 	}
 
+	if err := checkChunkFits(ch, uint64(cData.NumberTags)*ChunkTagEntryDataSize, "tag"); err != nil {
+		return nil, err
+	}
+
 	entries := make([]ChunkTagEntry, cData.NumberTags)
 	for i := range cData.NumberTags {
 		var entryData ChunkTagEntryData
@@ -1681,63 +2424,178 @@ func (l *Loader) ParseFrames(header *Header) ([]Frame, error) {
 	frames := make([]Frame, 0)
 
 	for i := range header.Frames {
-		fh, err := BytesToStruct[FrameHeader](l, FrameHeaderSize)
+		frame, err := l.parseFrame(int(i))
 		if err != nil {
 			return nil, err
 		}
-		err = checkMagicNumber(0xF1FA, fh.MagicNumber, "frameheader "+fmt.Sprint(i))
+
+		frames = append(frames, frame)
+	}
+
+	return frames, nil
+}
+
+// parseFrame reads the frame at the reader's position, frameId being its
+// index in the file.
+func (l *Loader) parseFrame(frameId int) (Frame, error) {
+	if err := l.done(); err != nil {
+		return Frame{}, err
+	}
+
+	frameStart := l.offset()
+	fh, err := BytesToStruct[FrameHeader](l, FrameHeaderSize)
+	if err != nil {
+		return Frame{}, err
+	}
+	err = checkMagicNumber(0xF1FA, fh.MagicNumber, "frameheader "+fmt.Sprint(frameId))
+	if err != nil {
+		return Frame{}, err
+	}
+
+	// files written before the new field existed leave it at 0
+	chunkNumber := fh.ChunkNumber
+	if chunkNumber == 0 && fh.OldChunkNumber != 0xFFFF {
+		chunkNumber = uint32(fh.OldChunkNumber)
+	}
+
+	prev := l.palette
+	chunkList := make([]Chunk, 0)
+	for range chunkNumber {
+		if err := l.done(); err != nil {
+			return Frame{}, err
+		}
+
+		ch, err := BytesToStruct[ChunkHeader](l, ChunkHeaderSize)
 		if err != nil {
-			return nil, err
+			return Frame{}, err
 		}
 
-		chunkList := make([]Chunk, 0)
-		// TODO: verificar o numero antigo
-		for range fh.ChunkNumber {
-			ch, err := BytesToStruct[ChunkHeader](l, ChunkHeaderSize)
-			if err != nil {
-				return nil, err
-			}
+		if ch.Size < ChunkHeaderSize {
+			return Frame{}, fmt.Errorf("chunk: %w (got %d)", ErrInvalidChunkSize, ch.Size)
+		}
 
-			var c Chunk
-			c, err = l.ParseChunk(ch, int(i))
-			if err != nil {
-				return nil, err
-			}
+		if err := l.Options.checkChunk(ch); err != nil {
+			return Frame{}, err
+		}
+
+		var c Chunk
+		switch {
+		case ch.Type == CelChunkHex && l.Options.Pixels == PixelModeSkip:
+			c, err = l.skipCelChunk(ch, frameId)
+		case ch.Type == TilesetChunkHex && l.Options.Pixels == PixelModeSkip:
+			c, err = l.skipTilesetChunk(ch, frameId)
+		default:
+			c, err = l.ParseBoundedChunk(ch, frameId)
+		}
+		if err != nil {
+			return Frame{}, err
+		}
+
+		// mask and path chunks carry nothing we keep
+		if c == nil {
+			continue
+		}
+
+		chunkList = append(chunkList, c)
 
-			chunkList = append(chunkList, c)
+		switch c.(type) {
+		case *ChunkPalette, *ChunkOldPalette, *ChunkOldPalette2:
+			l.palette, l.colors = framePalette(prev, chunkList), nil
 		}
+	}
 
-		frames = append(frames, Frame{Header: fh, Chunks: chunkList})
-		// NOTE: depois de ler dar um reset no buffer? Vê se tem um resto guardar e depois
-		// coloca no buffer de novo
+	if err := l.syncTo(frameStart+int64(fh.FrameBytes), "frame", frameId, 0); err != nil {
+		return Frame{}, err
 	}
 
-	return frames, nil
+	return Frame{Header: fh, Chunks: chunkList}, nil
 }
 
-func DeserializeFile(fd *os.File) (*AsepriteFile, error) {
-	var ase *AsepriteFile = new(AsepriteFile)
-	loader := new(Loader)
+// checkFileEnd compares what was read against the header's file size and
+// looks for data left after the last frame.
+func (l *Loader) checkFileEnd(header *Header) error {
+	frame := int(header.Frames) - 1
+	if pos := l.offset(); pos != int64(header.FileSize) {
+		w := Warning{Frame: frame, Message: fmt.Sprintf("header declares %d bytes, read %d", header.FileSize, pos)}
+		if err := l.warn(w, ErrSizeMismatch); err != nil {
+			return err
+		}
+	}
+
+	if l.Buffer.Len() == 0 {
+		err := l.readToBuffer()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	w := Warning{Frame: frame, Message: "data after the last frame"}
+	return l.warn(w, ErrTrailingData)
+}
+
+func DeserializeFile(r io.Reader) (*AsepriteFile, error) {
+	return Decode(r, DecodeOptions{})
+}
 
-	reader := fd
+// Decode reads a whole file from r. Unless opts.Strict is set, quirks such as
+// chunk sizes that disagree with their content are tolerated and listed in
+// the returned file's Warnings.
+func Decode(r io.Reader, opts DecodeOptions) (*AsepriteFile, error) {
+	return DecodeContext(context.Background(), r, opts)
+}
+
+// DecodeContext is Decode stopping with ctx.Err() once ctx is done. The
+// context is checked between frames and chunks, and between cels when
+// opts.Workers decode them in parallel.
+func DecodeContext(ctx context.Context, r io.Reader, opts DecodeOptions) (*AsepriteFile, error) {
+	loader := new(Loader)
 
 	loader.Buf = make([]byte, ChunkSize)
 	loader.Buffer = new(bytes.Buffer)
-	loader.Reader = reader
-	loader.File = ase
+	loader.Reader = r
+	loader.File = new(AsepriteFile)
+	loader.Options = opts
+	loader.ctx = ctx
+	if opts.Pixels == PixelModeEager && opts.workers() > 1 {
+		loader.cels = new(celQueue)
+	}
 
-	header, err := loader.ParseHeader()
+	return loader.decode()
+}
+
+// decode reads the file of a loader set up by DecodeContext or a Decoder.
+func (l *Loader) decode() (*AsepriteFile, error) {
+	ase := l.File
+
+	header, err := l.ParseHeader()
 	if err != nil {
 		return nil, err
 	}
+
+	if err := l.Options.checkHeader(header); err != nil {
+		return nil, err
+	}
 	ase.Header = header
-	loader.Buffer.Reset()
-	frames, err := loader.ParseFrames(&header)
+	frames, err := l.ParseFrames(&header)
+	if l.cels != nil {
+		// a queued cel may hold an error serial decoding would have hit
+		// first
+		if cerr := l.decodeCels(); cerr != nil && (err == nil || l.done() == nil) {
+			err = cerr
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 	ase.Frames = frames
 
+	if err := l.checkFileEnd(&header); err != nil {
+		return nil, err
+	}
+
 	return ase, nil
 }
 
@@ -1768,18 +2626,35 @@ func joinImagesHorizontally(images []image.Image) image.Image {
 }
 
 func (a *AsepriteFile) SpriteSheet() (image.Image, error) {
+	return a.SpriteSheetContext(context.Background())
+}
+
+// SpriteSheetContext is SpriteSheet stopping with ctx.Err() once ctx is
+// done, checked between cels.
+func (a *AsepriteFile) SpriteSheetContext(ctx context.Context) (image.Image, error) {
 	sprites := make([]image.Image, 0)
 
 	for _, frame := range a.Frames {
 		sprite := image.NewRGBA(image.Rect(0, 0, int(a.Header.Width), int(a.Header.Height)))
 
 		for _, chunk := range frame.Chunks {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
 			switch chunk.(type) {
 			case *ChunkCelImage:
 				c := chunk.(*ChunkCelImage)
-				pixels := c.ChunkCelRawImageData.Pixels.(PixelsRGBA)
-				img := pixels.ToImage(int(c.X), int(c.Y), int(c.ChunkCelDimensionData.Width), int(c.ChunkCelDimensionData.Height), int(a.Header.Width), int(a.Header.Height))
-				draw.Draw(sprite, sprite.Bounds(), img, sprite.Bounds().Min, draw.Over)
+				p, err := c.LoadPixels()
+				if err != nil {
+					return nil, fmt.Errorf("spritesheet: %w", err)
+				}
+				img, ok := p.(*image.NRGBA)
+				if !ok {
+					return nil, fmt.Errorf("spritesheet: %w (got %T, want RGBA)", ErrInvalidPixels, p)
+				}
+				bounds := img.Bounds()
+				draw.Draw(sprite, bounds.Sub(bounds.Min).Add(image.Pt(int(c.X), int(c.Y))), img, bounds.Min, draw.Over)
 			}
 		}
 
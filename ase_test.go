@@ -2,45 +2,54 @@ package ase
 
 import (
 	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
 	"image/png"
 	"os"
+	"reflect"
+	"runtime"
+	"sync"
 	"testing"
 )
 
 const testFilePath = "./test.aseprite"
 
-func TestChunkCel(t *testing.T) {
-	data := []byte{
-		0x03, 0x00,             // Layer index = 3
-		0x0A, 0x00,             // X = 10
-		0x14, 0x00,             // Y = 20
-		0xFF,                   // Opacity = 255
-		0x02, 0x00,             // Cel Type = 2 (Compressed Image)
-		0x00, 0x00,             // Z-index = 0
-
-		// Reserved (5 bytes)
-		0x00, 0x00, 0x00, 0x00, 0x00,
+var celChunkFixture = []byte{
+	0x03, 0x00,             // Layer index = 3
+	0x0A, 0x00,             // X = 10
+	0x14, 0x00,             // Y = 20
+	0xFF,                   // Opacity = 255
+	0x02, 0x00,             // Cel Type = 2 (Compressed Image)
+	0x00, 0x00,             // Z-index = 0
+
+	// Reserved (5 bytes)
+	0x00, 0x00, 0x00, 0x00, 0x00,
+
+	// Cel data for type 2
+	0x22, 0x00,             // Width = 34
+	0x22, 0x00,             // Height = 34
+	0x78, 0x9c, 0xed, 0x95, 0xb1, 0x0d, 0xc2, 0x30, 0x10, 0x45, 0x19, 0x08, 0x51, 0x40, 0x07, 0x15,
+	0xa2, 0xa7, 0xcd, 0x00, 0xd4, 0x88, 0x01, 0x32, 0x00, 0x55, 0x76, 0xa1, 0x85, 0x01, 0x28, 0x69,
+	0x11, 0x23, 0x30, 0x45, 0x10, 0x96, 0x1c, 0x45, 0xd6, 0xd9, 0x77, 0xff, 0x7c, 0x38, 0x05, 0xfe,
+	0xd2, 0xef, 0x4e, 0xff, 0x3f, 0x9d, 0x7c, 0xc9, 0xb3, 0xbd, 0xf5, 0xb3, 0xaa, 0xaa, 0xaa, 0xaa,
+	0x3f, 0xd4, 0xfb, 0xd4, 0xf6, 0x31, 0x97, 0xec, 0x7e, 0x35, 0x87, 0xa8, 0x7f, 0xc5, 0x24, 0xe9,
+	0xe6, 0x98, 0x2c, 0x18, 0x34, 0xfd, 0x14, 0x4f, 0xe9, 0x1d, 0x58, 0xed, 0xc6, 0xa2, 0xff, 0x38,
+	0x5f, 0x3a, 0x6b, 0x77, 0x93, 0x62, 0x48, 0x65, 0x87, 0x73, 0xf7, 0x73, 0xe7, 0xac, 0x61, 0xe1,
+	0x18, 0xb8, 0xec, 0xd8, 0x2c, 0xca, 0x12, 0xe3, 0xf0, 0x59, 0x08, 0x47, 0x68, 0x4b, 0x0e, 0x2e,
+	0x37, 0x9c, 0xef, 0x36, 0x3b, 0x67, 0xe4, 0x8e, 0xb8, 0xb7, 0xe9, 0x33, 0x25, 0xef, 0x03, 0xf5,
+	0x98, 0xc3, 0x3a, 0x5b, 0xc3, 0x32, 0x35, 0x43, 0xe5, 0xc8, 0xe3, 0x90, 0xde, 0x0c, 0x72, 0x5b,
+	0x28, 0x07, 0x75, 0xbb, 0x54, 0x87, 0x74, 0x4e, 0xc3, 0x11, 0xcb, 0x46, 0x3c, 0x25, 0xc7, 0x65,
+	0xb1, 0x1e, 0x2c, 0xe1, 0xe0, 0x58, 0xb4, 0xfd, 0x8f, 0xd5, 0x76, 0x70, 0x49, 0x0e, 0xaa, 0x1f,
+	0xe5, 0xc8, 0x61, 0x49, 0xf5, 0x4b, 0x38, 0xa8, 0x7f, 0x1d, 0xca, 0xc2, 0xf5, 0xa3, 0xbb, 0x40,
+	0xfe, 0x35, 0x08, 0x83, 0x66, 0x17, 0x5e, 0xd7, 0x7d, 0x23, 0xfa, 0xf6, 0xe4, 0xf8, 0xdb, 0x91,
+	0x62, 0x18, 0xb3, 0x58, 0xf3, 0xf8, 0x4c, 0x29, 0x03, 0xc5, 0x63, 0xe1, 0x54, 0xcf, 0x07, 0x7d,
+	0x11, 0xf7, 0x9b,
+}
 
-		// Cel data for type 2
-		0x22, 0x00,             // Width = 34
-		0x22, 0x00,             // Height = 34
-		0x78, 0x9c, 0xed, 0x95, 0xb1, 0x0d, 0xc2, 0x30, 0x10, 0x45, 0x19, 0x08, 0x51, 0x40, 0x07, 0x15,
-		0xa2, 0xa7, 0xcd, 0x00, 0xd4, 0x88, 0x01, 0x32, 0x00, 0x55, 0x76, 0xa1, 0x85, 0x01, 0x28, 0x69,
-		0x11, 0x23, 0x30, 0x45, 0x10, 0x96, 0x1c, 0x45, 0xd6, 0xd9, 0x77, 0xff, 0x7c, 0x38, 0x05, 0xfe,
-		0xd2, 0xef, 0x4e, 0xff, 0x3f, 0x9d, 0x7c, 0xc9, 0xb3, 0xbd, 0xf5, 0xb3, 0xaa, 0xaa, 0xaa, 0xaa,
-		0x3f, 0xd4, 0xfb, 0xd4, 0xf6, 0x31, 0x97, 0xec, 0x7e, 0x35, 0x87, 0xa8, 0x7f, 0xc5, 0x24, 0xe9,
-		0xe6, 0x98, 0x2c, 0x18, 0x34, 0xfd, 0x14, 0x4f, 0xe9, 0x1d, 0x58, 0xed, 0xc6, 0xa2, 0xff, 0x38,
-		0x5f, 0x3a, 0x6b, 0x77, 0x93, 0x62, 0x48, 0x65, 0x87, 0x73, 0xf7, 0x73, 0xe7, 0xac, 0x61, 0xe1,
-		0x18, 0xb8, 0xec, 0xd8, 0x2c, 0xca, 0x12, 0xe3, 0xf0, 0x59, 0x08, 0x47, 0x68, 0x4b, 0x0e, 0x2e,
-		0x37, 0x9c, 0xef, 0x36, 0x3b, 0x67, 0xe4, 0x8e, 0xb8, 0xb7, 0xe9, 0x33, 0x25, 0xef, 0x03, 0xf5,
-		0x98, 0xc3, 0x3a, 0x5b, 0xc3, 0x32, 0x35, 0x43, 0xe5, 0xc8, 0xe3, 0x90, 0xde, 0x0c, 0x72, 0x5b,
-		0x28, 0x07, 0x75, 0xbb, 0x54, 0x87, 0x74, 0x4e, 0xc3, 0x11, 0xcb, 0x46, 0x3c, 0x25, 0xc7, 0x65,
-		0xb1, 0x1e, 0x2c, 0xe1, 0xe0, 0x58, 0xb4, 0xfd, 0x8f, 0xd5, 0x76, 0x70, 0x49, 0x0e, 0xaa, 0x1f,
-		0xe5, 0xc8, 0x61, 0x49, 0xf5, 0x4b, 0x38, 0xa8, 0x7f, 0x1d, 0xca, 0xc2, 0xf5, 0xa3, 0xbb, 0x40,
-		0xfe, 0x35, 0x08, 0x83, 0x66, 0x17, 0x5e, 0xd7, 0x7d, 0x23, 0xfa, 0xf6, 0xe4, 0xf8, 0xdb, 0x91,
-		0x62, 0x18, 0xb3, 0x58, 0xf3, 0xf8, 0x4c, 0x29, 0x03, 0xc5, 0x63, 0xe1, 0x54, 0xcf, 0x07, 0x7d,
-		0x11, 0xf7, 0x9b,
-	}
+func TestChunkCel(t *testing.T) {
+	data := celChunkFixture
 
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
@@ -85,49 +94,145 @@ func TestChunkCel(t *testing.T) {
 	}
 }
 
-func TestChunkTileset(t *testing.T) {
-	data := []byte{
-		0x11, 0x00, 0x00, 0x00, // Tileset ID = 17
-		0x3F, 0x00, 0x00, 0x00, // Flags = 63
-		0x02, 0x00, 0x00, 0x00, // Number of tiles = 2
-		0x10, 0x00,             // Tile width = 16
-		0x10, 0x00,             // Tile height = 16
-		0x01, 0x00,             // Base index = 1
-
-		// Reserved 14 bytes
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00,
-
-		// STRING: "Terrain"
-		0x07, 0x00,             // Length = 7
-		'T', 'e', 'r', 'r', 'a', 'i', 'n',
-
-		// Flag 1 → external file link
-		0x2A, 0x00, 0x00, 0x00, // External file ID = 42
-		0x07, 0x00, 0x00, 0x00, // External tileset ID = 7
-
-		// Flag 2 → inline image data
-		0xf3, 0x00, 0x00, 0x00, // length = 243 bytes
-		0x78, 0x9c, 0xed, 0x95, 0xb1, 0x0d, 0xc2, 0x30, 0x10, 0x45, 0x19, 0x08, 0x51, 0x40, 0x07, 0x15,
-		0xa2, 0xa7, 0xcd, 0x00, 0xd4, 0x88, 0x01, 0x32, 0x00, 0x55, 0x76, 0xa1, 0x85, 0x01, 0x28, 0x69,
-		0x11, 0x23, 0x30, 0x45, 0x10, 0x96, 0x1c, 0x45, 0xd6, 0xd9, 0x77, 0xff, 0x7c, 0x38, 0x05, 0xfe,
-		0xd2, 0xef, 0x4e, 0xff, 0x3f, 0x9d, 0x7c, 0xc9, 0xb3, 0xbd, 0xf5, 0xb3, 0xaa, 0xaa, 0xaa, 0xaa,
-		0x3f, 0xd4, 0xfb, 0xd4, 0xf6, 0x31, 0x97, 0xec, 0x7e, 0x35, 0x87, 0xa8, 0x7f, 0xc5, 0x24, 0xe9,
-		0xe6, 0x98, 0x2c, 0x18, 0x34, 0xfd, 0x14, 0x4f, 0xe9, 0x1d, 0x58, 0xed, 0xc6, 0xa2, 0xff, 0x38,
-		0x5f, 0x3a, 0x6b, 0x77, 0x93, 0x62, 0x48, 0x65, 0x87, 0x73, 0xf7, 0x73, 0xe7, 0xac, 0x61, 0xe1,
-		0x18, 0xb8, 0xec, 0xd8, 0x2c, 0xca, 0x12, 0xe3, 0xf0, 0x59, 0x08, 0x47, 0x68, 0x4b, 0x0e, 0x2e,
-		0x37, 0x9c, 0xef, 0x36, 0x3b, 0x67, 0xe4, 0x8e, 0xb8, 0xb7, 0xe9, 0x33, 0x25, 0xef, 0x03, 0xf5,
-		0x98, 0xc3, 0x3a, 0x5b, 0xc3, 0x32, 0x35, 0x43, 0xe5, 0xc8, 0xe3, 0x90, 0xde, 0x0c, 0x72, 0x5b,
-		0x28, 0x07, 0x75, 0xbb, 0x54, 0x87, 0x74, 0x4e, 0xc3, 0x11, 0xcb, 0x46, 0x3c, 0x25, 0xc7, 0x65,
-		0xb1, 0x1e, 0x2c, 0xe1, 0xe0, 0x58, 0xb4, 0xfd, 0x8f, 0xd5, 0x76, 0x70, 0x49, 0x0e, 0xaa, 0x1f,
-		0xe5, 0xc8, 0x61, 0x49, 0xf5, 0x4b, 0x38, 0xa8, 0x7f, 0x1d, 0xca, 0xc2, 0xf5, 0xa3, 0xbb, 0x40,
-		0xfe, 0x35, 0x08, 0x83, 0x66, 0x17, 0x5e, 0xd7, 0x7d, 0x23, 0xfa, 0xf6, 0xe4, 0xf8, 0xdb, 0x91,
-		0x62, 0x18, 0xb3, 0x58, 0xf3, 0xf8, 0x4c, 0x29, 0x03, 0xc5, 0x63, 0xe1, 0x54, 0xcf, 0x07, 0x7d,
-		0x11, 0xf7, 0x9b,
+func TestChunkCelSizeValidation(t *testing.T) {
+	// the fixture inflates to 34x36 pixels while declaring 34x34
+	data := celChunkFixture
+
+	chunkHeader := ChunkHeader{
+		Size: uint32(len(data)) + ChunkHeaderSize,
+		Type: CelChunkHex,
 	}
 
+	file := &AsepriteFile{Header: Header{Width: 36, Height: 36, ColorDepth: ColorDepthRGBA}}
+
+	loader := &Loader{Buffer: bytes.NewBuffer(data), File: file, Options: DecodeOptions{Strict: true}}
+	if _, err := loader.ParseChunkCel(chunkHeader, 0); !errors.Is(err, ErrInvalidPixels) {
+		t.Errorf("expected ErrInvalidPixels in strict mode, got %v", err)
+	}
+
+	loader = &Loader{Buffer: bytes.NewBuffer(data), File: file}
+	chunk, err := loader.ParseChunkCel(chunkHeader, 0)
+	if err != nil {
+		t.Fatalf("failed to parse ChunkCel: %v", err)
+	}
+
+	chunkCel := chunk.(*ChunkCelImage)
+
+	if len(chunkCel.Warnings) != 1 {
+		t.Errorf("unexpected number of warnings: got %d, want %d", len(chunkCel.Warnings), 1)
+	}
+
+	if pixels := chunkCel.Pixels.(*image.NRGBA); len(pixels.Pix) != 34*34*4 || pixels.Rect != image.Rect(0, 0, 34, 34) {
+		t.Errorf("unexpected pixels: got %d bytes in %v, want %d", len(pixels.Pix), pixels.Rect, 34*34*4)
+	}
+
+	loader = &Loader{Buffer: bytes.NewBuffer(data), File: file, Options: DecodeOptions{Pixels: PixelModeLazy}}
+	chunk, err = loader.ParseChunkCel(chunkHeader, 0)
+	if err != nil {
+		t.Fatalf("failed to parse ChunkCel lazily: %v", err)
+	}
+
+	// lazy cels are loaded from any goroutine
+	chunkCel = chunk.(*ChunkCelImage)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := chunkCel.LoadPixels(); err != nil {
+				t.Errorf("failed to load pixels: %v", err)
+			}
+			if n := len(chunkCel.PixelWarnings()); n != 1 {
+				t.Errorf("unexpected number of pixel warnings: got %d, want 1", n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(chunkCel.Warnings) != 0 || chunkCel.Pixels != nil {
+		t.Errorf("expected a lazy cel to keep its fields, got %v and %T", chunkCel.Warnings, chunkCel.Pixels)
+	}
+}
+
+func TestChunkCelPadding(t *testing.T) {
+	file := &AsepriteFile{Header: Header{Width: 64, Height: 64, ColorDepth: ColorDepthRGBA}}
+
+	tests := []struct {
+		height int
+		err    error
+	}{
+		// 16x16 pixels of data for 16x17: one row is padded
+		{17, nil},
+		// 16x16 pixels of data for 16x64: most of the image is missing
+		{64, ErrInvalidPixels},
+	}
+
+	for _, tt := range tests {
+		data := celFixture(16, 0)
+		binary.LittleEndian.PutUint16(data[ChunkCelDataSize+2:], uint16(tt.height))
+
+		loader := &Loader{Buffer: bytes.NewBuffer(data), File: file}
+		chunk, err := loader.ParseChunkCel(ChunkHeader{Size: uint32(len(data)) + ChunkHeaderSize, Type: CelChunkHex}, 0)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("16x%d: got error %v, want %v", tt.height, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		cel := chunk.(*ChunkCelImage)
+		if pixels := cel.Pixels.(*image.NRGBA); len(cel.Warnings) != 1 || pixels.Rect.Dy() != tt.height {
+			t.Errorf("16x%d: unexpected pixels %v with warnings %v", tt.height, pixels.Rect, cel.Warnings)
+		}
+	}
+}
+
+var tilesetChunkFixture = []byte{
+	0x11, 0x00, 0x00, 0x00, // Tileset ID = 17
+	0x3F, 0x00, 0x00, 0x00, // Flags = 63
+	0x02, 0x00, 0x00, 0x00, // Number of tiles = 2
+	0x10, 0x00,             // Tile width = 16
+	0x10, 0x00,             // Tile height = 16
+	0x01, 0x00,             // Base index = 1
+
+	// Reserved 14 bytes
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00,
+
+	// STRING: "Terrain"
+	0x07, 0x00,             // Length = 7
+	'T', 'e', 'r', 'r', 'a', 'i', 'n',
+
+	// Flag 1 → external file link
+	0x2A, 0x00, 0x00, 0x00, // External file ID = 42
+	0x07, 0x00, 0x00, 0x00, // External tileset ID = 7
+
+	// Flag 2 → inline image data
+	0xf3, 0x00, 0x00, 0x00, // length = 243 bytes
+	0x78, 0x9c, 0xed, 0x95, 0xb1, 0x0d, 0xc2, 0x30, 0x10, 0x45, 0x19, 0x08, 0x51, 0x40, 0x07, 0x15,
+	0xa2, 0xa7, 0xcd, 0x00, 0xd4, 0x88, 0x01, 0x32, 0x00, 0x55, 0x76, 0xa1, 0x85, 0x01, 0x28, 0x69,
+	0x11, 0x23, 0x30, 0x45, 0x10, 0x96, 0x1c, 0x45, 0xd6, 0xd9, 0x77, 0xff, 0x7c, 0x38, 0x05, 0xfe,
+	0xd2, 0xef, 0x4e, 0xff, 0x3f, 0x9d, 0x7c, 0xc9, 0xb3, 0xbd, 0xf5, 0xb3, 0xaa, 0xaa, 0xaa, 0xaa,
+	0x3f, 0xd4, 0xfb, 0xd4, 0xf6, 0x31, 0x97, 0xec, 0x7e, 0x35, 0x87, 0xa8, 0x7f, 0xc5, 0x24, 0xe9,
+	0xe6, 0x98, 0x2c, 0x18, 0x34, 0xfd, 0x14, 0x4f, 0xe9, 0x1d, 0x58, 0xed, 0xc6, 0xa2, 0xff, 0x38,
+	0x5f, 0x3a, 0x6b, 0x77, 0x93, 0x62, 0x48, 0x65, 0x87, 0x73, 0xf7, 0x73, 0xe7, 0xac, 0x61, 0xe1,
+	0x18, 0xb8, 0xec, 0xd8, 0x2c, 0xca, 0x12, 0xe3, 0xf0, 0x59, 0x08, 0x47, 0x68, 0x4b, 0x0e, 0x2e,
+	0x37, 0x9c, 0xef, 0x36, 0x3b, 0x67, 0xe4, 0x8e, 0xb8, 0xb7, 0xe9, 0x33, 0x25, 0xef, 0x03, 0xf5,
+	0x98, 0xc3, 0x3a, 0x5b, 0xc3, 0x32, 0x35, 0x43, 0xe5, 0xc8, 0xe3, 0x90, 0xde, 0x0c, 0x72, 0x5b,
+	0x28, 0x07, 0x75, 0xbb, 0x54, 0x87, 0x74, 0x4e, 0xc3, 0x11, 0xcb, 0x46, 0x3c, 0x25, 0xc7, 0x65,
+	0xb1, 0x1e, 0x2c, 0xe1, 0xe0, 0x58, 0xb4, 0xfd, 0x8f, 0xd5, 0x76, 0x70, 0x49, 0x0e, 0xaa, 0x1f,
+	0xe5, 0xc8, 0x61, 0x49, 0xf5, 0x4b, 0x38, 0xa8, 0x7f, 0x1d, 0xca, 0xc2, 0xf5, 0xa3, 0xbb, 0x40,
+	0xfe, 0x35, 0x08, 0x83, 0x66, 0x17, 0x5e, 0xd7, 0x7d, 0x23, 0xfa, 0xf6, 0xe4, 0xf8, 0xdb, 0x91,
+	0x62, 0x18, 0xb3, 0x58, 0xf3, 0xf8, 0x4c, 0x29, 0x03, 0xc5, 0x63, 0xe1, 0x54, 0xcf, 0x07, 0x7d,
+	0x11, 0xf7, 0x9b,
+}
+
+func TestChunkTileset(t *testing.T) {
+	data := tilesetChunkFixture
+
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
 		Type: TilesetChunkHex,
@@ -171,29 +276,31 @@ func TestChunkTileset(t *testing.T) {
 	}
 }
 
-func TestChunkTag(t *testing.T) {
-	data := []byte{
-		0x01, 0x00,             // Number of tags = 1
-		0x00, 0x00, 0x00, 0x00, // Reserved (8 bytes)
-		0x00, 0x00, 0x00, 0x00,
+var tagChunkFixture = []byte{
+	0x01, 0x00,             // Number of tags = 1
+	0x00, 0x00, 0x00, 0x00, // Reserved (8 bytes)
+	0x00, 0x00, 0x00, 0x00,
 
-		// Tag 1
-		0x00, 0x00,             // From frame = 0
-		0x05, 0x00,             // To frame = 5
-		0x02,                   // Loop direction = 2 (ping-pong)
-		0x03, 0x00,             // Repeat = 3
+	// Tag 1
+	0x00, 0x00,             // From frame = 0
+	0x05, 0x00,             // To frame = 5
+	0x02,                   // Loop direction = 2 (ping-pong)
+	0x03, 0x00,             // Repeat = 3
 
-		// Reserved 6 bytes
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	// Reserved 6 bytes
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 
-		// RGB color (deprecated)
-		0xFF, 0x00, 0x80,       // RGB = (255, 0, 128)
-		0x00,                   // Extra byte (zero)
+	// RGB color (deprecated)
+	0xFF, 0x00, 0x80,       // RGB = (255, 0, 128)
+	0x00,                   // Extra byte (zero)
 
-		// STRING: "Run"
-		0x03, 0x00,             // Length = 3
-		'R', 'u', 'n',
-	}
+	// STRING: "Run"
+	0x03, 0x00,             // Length = 3
+	'R', 'u', 'n',
+}
+
+func TestChunkTag(t *testing.T) {
+	data := tagChunkFixture
 
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
@@ -242,30 +349,32 @@ func TestChunkTag(t *testing.T) {
 	}
 }
 
-func TestChunkSlice(t *testing.T) {
-	data := []byte{
-		0x01, 0x00, 0x00, 0x00, // Number of slice keys = 1
-		0x03, 0x00, 0x00, 0x00, // Flags = 3 (has 9-patch + pivot)
-		0x00, 0x00, 0x00, 0x00, // Reserved
+var sliceChunkFixture = []byte{
+	0x01, 0x00, 0x00, 0x00, // Number of slice keys = 1
+	0x03, 0x00, 0x00, 0x00, // Flags = 3 (has 9-patch + pivot)
+	0x00, 0x00, 0x00, 0x00, // Reserved
 
-		0x06, 0x00, // Name length = 7
-		'H', 'P', ' ', 'B', 'a', 'r',
+	0x06, 0x00, // Name length = 7
+	'H', 'P', ' ', 'B', 'a', 'r',
 
-		// Slice key
-		0x00, 0x00, 0x00, 0x00, // Frame = 0
-		0x0A, 0x00, 0x00, 0x00, // X = 10
-		0x14, 0x00, 0x00, 0x00, // Y = 20
-		0x64, 0x00, 0x00, 0x00, // Width = 100
-		0x1E, 0x00, 0x00, 0x00, // Height = 30
+	// Slice key
+	0x00, 0x00, 0x00, 0x00, // Frame = 0
+	0x0A, 0x00, 0x00, 0x00, // X = 10
+	0x14, 0x00, 0x00, 0x00, // Y = 20
+	0x64, 0x00, 0x00, 0x00, // Width = 100
+	0x1E, 0x00, 0x00, 0x00, // Height = 30
 
-		0x05, 0x00, 0x00, 0x00, // Center X = 5
-		0x05, 0x00, 0x00, 0x00, // Center Y = 5
-		0x5A, 0x00, 0x00, 0x00, // Center Width = 90
-		0x14, 0x00, 0x00, 0x00, // Center Height = 20
+	0x05, 0x00, 0x00, 0x00, // Center X = 5
+	0x05, 0x00, 0x00, 0x00, // Center Y = 5
+	0x5A, 0x00, 0x00, 0x00, // Center Width = 90
+	0x14, 0x00, 0x00, 0x00, // Center Height = 20
 
-		0x32, 0x00, 0x00, 0x00, // Pivot X = 50
-		0x0F, 0x00, 0x00, 0x00, // Pivot Y = 15
-	}
+	0x32, 0x00, 0x00, 0x00, // Pivot X = 50
+	0x0F, 0x00, 0x00, 0x00, // Pivot Y = 15
+}
+
+func TestChunkSlice(t *testing.T) {
+	data := sliceChunkFixture
 
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
@@ -314,23 +423,25 @@ func TestChunkSlice(t *testing.T) {
 	}
 }
 
-func TestChunkExternalFiles(t *testing.T) {
-	data := []byte{
-		0x02, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-
-		0x2A, 0x00, 0x00, 0x00,
-		0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x10, 0x00,
-		'p', 'a', 'l', 'e', 't', 't', 'e', '.', 'a', 's', 'e', 'p', 'r', 'i', 't', 'e',
+var externalFilesChunkFixture = []byte{
+	0x02, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+
+	0x2A, 0x00, 0x00, 0x00,
+	0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x10, 0x00,
+	'p', 'a', 'l', 'e', 't', 't', 'e', '.', 'a', 's', 'e', 'p', 'r', 'i', 't', 'e',
+
+	0x2B, 0x00, 0x00, 0x00,
+	0x01,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x0B, 0x00,
+	't', 'i', 'l', 'e', 's', 'e', 't', '.', 't', 's', 'x',
+}
 
-		0x2B, 0x00, 0x00, 0x00,
-		0x01,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x0B, 0x00,
-		't', 'i', 'l', 'e', 's', 'e', 't', '.', 't', 's', 'x',
-	}
+func TestChunkExternalFiles(t *testing.T) {
+	data := externalFilesChunkFixture
 
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
@@ -379,18 +490,20 @@ func TestChunkExternalFiles(t *testing.T) {
 	}
 }
 
+var celExtraChunkFixture = []byte{
+	0x01, 0x00, 0x00, 0x00,
+	0x00, 0x80, 0xFE, 0xFF,
+	0x00, 0x40, 0x02, 0x00,
+	0x00, 0x00, 0x20, 0x00,
+	0x00, 0x00, 0x20, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
 func TestChunkCelExtra(t *testing.T) {
-	data := []byte{
-		0x01, 0x00, 0x00, 0x00,
-		0x00, 0x80, 0xFE, 0xFF,
-		0x00, 0x40, 0x02, 0x00,
-		0x00, 0x00, 0x20, 0x00,
-		0x00, 0x00, 0x20, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-	}
+	data := celExtraChunkFixture
 
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
@@ -444,24 +557,26 @@ func TestChunkCelExtra(t *testing.T) {
 }
 
 
+var paletteChunkFixture = []byte{
+	0x03, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+	0x02, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	// --- Entry 0 ---
+	0x00, 0x00, // flags
+	0xFF, 0x00, 0x00, 0xFF, // red
+	// --- Entry 1 ---
+	0x00, 0x00, // flags
+	0x00, 0xFF, 0x00, 0x80, // green, half transparent
+	// --- Entry 2 ---
+	0x01, 0x00, // flags (has name)
+	0x00, 0x00, 0xFF, 0xFF, // blue
+	// "Azulão" + null
+	0x06, 0x00, 'a', 'z', 'u', 'l', 'a', 'o',
+}
+
 func TestChunkPalette(t *testing.T) {
-	data := []byte{
-		0x03, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-		0x02, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		// --- Entry 0 ---
-		0x00, 0x00, // flags
-		0xFF, 0x00, 0x00, // red
-		// --- Entry 1 ---
-		0x00, 0x00, // flags
-		0x00, 0xFF, 0x00, // green
-		// --- Entry 2 ---
-		0x01, 0x00, // flags (has name)
-		0x00, 0x00, 0xFF, // blue
-		// "Azulão" + null
-		0x06, 0x00, 'a', 'z', 'u', 'l', 'a', 'o',
-	}
+	data := paletteChunkFixture
 
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
@@ -512,21 +627,27 @@ func TestChunkPalette(t *testing.T) {
 	if chunkPalette.Entries[2].ColorName != "azulao" {
 		t.Errorf("unexpected entry name: got %s, want %s", chunkPalette.Entries[2].ColorName, "Azulão")
 	}
+
+	if e := chunkPalette.Entries[1]; e.Red != 0x00 || e.Green != 0xFF || e.Blue != 0x00 || e.Alpha != 0x80 {
+		t.Errorf("unexpected entry color: got %+v, want green with alpha %d", e, 0x80)
+	}
+}
+
+var layerChunkFixture = []byte{
+	0x0B, 0x00,
+	0x00, 0x00,
+	0x00, 0x00,
+	0x00, 0x00,
+	0x00, 0x00,
+	0x00, 0x00,
+	0xFF,
+	0x00, 0x00, 0x00,
+	0x05, 0x00,
+	'L', 'a', 'y', 'e', 'r',
 }
 
 func TestChunkLayer(t *testing.T) {
-	data := []byte{
-		0x0B, 0x00,
-		0x00, 0x00,
-		0x00, 0x00,
-		0x00, 0x00,
-		0x00, 0x00,
-		0x00, 0x00,
-		0xFF,
-		0x00, 0x00, 0x00,
-		0x05, 0x00,
-		'L', 'a', 'y', 'e', 'r',
-	}
+	data := layerChunkFixture
 
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
@@ -575,8 +696,115 @@ func TestChunkLayer(t *testing.T) {
 	}
 }
 
+func TestChunkCelTilemap(t *testing.T) {
+	tiles := new(bytes.Buffer)
+	w := zlib.NewWriter(tiles)
+	for _, tile := range []uint32{1, 2, 3, 0x80000004} {
+		binary.Write(w, binary.LittleEndian, tile)
+	}
+	w.Close()
+
+	data := []byte{
+		0x00, 0x00, // Layer index = 0
+		0x00, 0x00, // X = 0
+		0x00, 0x00, // Y = 0
+		0xFF,       // Opacity = 255
+		0x03, 0x00, // Cel Type = 3 (Compressed Tilemap)
+		0x00, 0x00, // Z-index = 0
+		0x00, 0x00, 0x00, 0x00, 0x00,
+
+		0x02, 0x00, // Width = 2
+		0x02, 0x00, // Height = 2
+		0x20, 0x00, // Bits per tile = 32
+		0xFF, 0xFF, 0xFF, 0x1F, // Tile ID mask
+		0x00, 0x00, 0x00, 0x20, // X flip mask
+		0x00, 0x00, 0x00, 0x40, // Y flip mask
+		0x00, 0x00, 0x00, 0x80, // Diagonal flip mask
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	data = append(data, tiles.Bytes()...)
+
+	chunkHeader := ChunkHeader{
+		Size: uint32(len(data)) + ChunkHeaderSize,
+		Type: CelChunkHex,
+	}
+
+	loader := &Loader{Buffer: bytes.NewBuffer(data), File: &AsepriteFile{Header: Header{ColorDepth: ColorDepthRGBA}}}
+
+	chunk, err := loader.ParseBoundedChunk(chunkHeader, 0)
+	if err != nil {
+		t.Fatalf("failed to parse ChunkCel: %v", err)
+	}
+
+	if unread := loader.Buffer.Len(); unread != 0 {
+		t.Errorf("expected ChunkCel to be fully read, but %d bytes remain", unread)
+	}
+
+	chunkTilemap := chunk.(*ChunkCelTilemap)
+
+	if len(chunkTilemap.Tiles) != 4 {
+		t.Fatalf("unexpected number of tiles: got %d, want %d", len(chunkTilemap.Tiles), 4)
+	}
+
+	if id := chunkTilemap.Tiles[3] & chunkTilemap.MaskTileId; id != 4 {
+		t.Errorf("unexpected tile id: got %d, want %d", id, 4)
+	}
+
+	if chunkTilemap.Tiles[3]&chunkTilemap.MaskDiagonalFlip == 0 {
+		t.Errorf("expected tile 3 to be flipped diagonally")
+	}
+}
+
+func TestParseBoundedChunk(t *testing.T) {
+	file := &AsepriteFile{}
+	next := []byte{0xAA, 0xBB}
+
+	// three extra bytes after the layer name
+	data := append(append([]byte(nil), layerChunkFixture...), 0x00, 0x00, 0x00)
+	loader := &Loader{Buffer: bytes.NewBuffer(append(data, next...)), File: file}
+	chunk, err := loader.ParseBoundedChunk(ChunkHeader{Size: uint32(len(data)) + ChunkHeaderSize, Type: LayerChunkHex}, 0)
+	if err != nil {
+		t.Fatalf("failed to parse ChunkLayer: %v", err)
+	}
+
+	if chunk.(*ChunkLayer).ChunkLayerName != "Layer" {
+		t.Errorf("unexpected layer name: got %q, want \"Layer\"", chunk.(*ChunkLayer).ChunkLayerName)
+	}
+
+	if !bytes.Equal(loader.Buffer.Bytes(), next) {
+		t.Errorf("expected loader at the next chunk, got % X", loader.Buffer.Bytes())
+	}
+
+	// the declared size cuts the layer name short
+	data = layerChunkFixture[:len(layerChunkFixture)-2]
+	loader = &Loader{Buffer: bytes.NewBuffer(append(append([]byte(nil), data...), next...)), File: file}
+	chunk, err = loader.ParseBoundedChunk(ChunkHeader{Size: uint32(len(data)) + ChunkHeaderSize, Type: LayerChunkHex}, 0)
+	if err != nil {
+		t.Fatalf("failed to parse ChunkLayer: %v", err)
+	}
+
+	if chunk != nil {
+		t.Errorf("expected the overrunning chunk to be dropped, got %v", chunk)
+	}
+
+	if !bytes.Equal(loader.Buffer.Bytes(), next) {
+		t.Errorf("expected loader at the next chunk, got % X", loader.Buffer.Bytes())
+	}
+
+	if len(file.Warnings) != 2 {
+		t.Errorf("unexpected warnings: got %v, want 2 warnings", file.Warnings)
+	}
+
+	loader = &Loader{Buffer: bytes.NewBuffer(append([]byte(nil), data...)), File: file, Options: DecodeOptions{Strict: true}}
+	if _, err := loader.ParseBoundedChunk(ChunkHeader{Size: uint32(len(data)) + ChunkHeaderSize, Type: LayerChunkHex}, 0); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("expected ErrSizeMismatch in strict mode, got %v", err)
+	}
+}
+
+var oldPaletteChunkFixture = []byte{0x02, 0x00, 0x00, 0x02, 0xFF, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x01, 0x01, 0x00, 0x00, 0xFF}
+
 func TestChunkOldPalette(t *testing.T) {
-	data := []byte{0x02, 0x00, 0x00, 0x02, 0xFF, 0x00, 0x00, 0x00, 0xFF, 0x00, 0x01, 0x01, 0x00, 0x00, 0xFF}
+	data := oldPaletteChunkFixture
 	chunkHeader := ChunkHeader{
 		Size: uint32(len(data)) + ChunkHeaderSize,
 		Type: OldPaletteChunkHex,
@@ -587,7 +815,7 @@ func TestChunkOldPalette(t *testing.T) {
 		t.Fatalf("failed to write to buffer: %v", err)
 	}
 
-	_, err = loader.ParseChunkOldPalette(chunkHeader)
+	chunk, err := loader.ParseChunkOldPalette(chunkHeader)
 	if err != nil {
 		t.Fatalf("failed to parse ChunkOldPalette: %v", err)
 	}
@@ -595,6 +823,12 @@ func TestChunkOldPalette(t *testing.T) {
 	if unread := loader.Buffer.Len(); unread != 0 {
 		t.Errorf("expected ChunkOldPalette to be fully read, but %d bytes remain (read %d of %d)", unread, len(data)-unread, len(data))
 	}
+
+	// the second packet skips entry 2
+	want := []ChunkOldPaletteColor{{R: 0xFF}, {G: 0xFF}, {}, {B: 0xFF}}
+	if colors := chunk.(*ChunkOldPalette).Colors; !reflect.DeepEqual(colors, want) {
+		t.Errorf("unexpected colors: got %v, want %v", colors, want)
+	}
 }
 
 func TestDeserializeFile(t *testing.T) {
@@ -709,3 +943,197 @@ func verifyAllDataRead(t *testing.T, ase *AsepriteFile, filepath string) {
 		t.Errorf("expected file to be fully read, but %d bytes remain (read %d of %d)", fileSize-read, read, fileSize)
 	}
 }
+
+// benchmarkFiles are the generated files the benchmarks run on, see
+// animationFixture.
+var benchmarkFiles = []struct {
+	name   string
+	frames int
+	size   int
+}{
+	{"Small", 1, 16},
+	{"Medium", 16, 64},
+	{"Large", 64, 256},
+}
+
+// benchmarkSprites runs bench on each of benchmarkFiles, decoded.
+func benchmarkSprites(b *testing.B, bench func(b *testing.B, sprite *Sprite)) {
+	for _, file := range benchmarkFiles {
+		b.Run(file.name, func(b *testing.B) {
+			ase, err := DeserializeFile(bytes.NewReader(animationFixture(file.frames, file.size)))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			sprite, err := ase.Sprite()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			bench(b, sprite)
+		})
+	}
+}
+
+func BenchmarkParseHeader(b *testing.B) {
+	data := animationFixture(1, 16)[:HeaderSize]
+	b.SetBytes(HeaderSize)
+	b.ReportAllocs()
+
+	for b.Loop() {
+		loader := &Loader{Buffer: bytes.NewBuffer(data)}
+		if _, err := loader.ParseHeader(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeserializeFile(b *testing.B) {
+	for _, file := range benchmarkFiles {
+		b.Run(file.name, func(b *testing.B) {
+			data := animationFixture(file.frames, file.size)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+
+			for b.Loop() {
+				if _, err := DeserializeFile(bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseChunk(b *testing.B) {
+	for _, c := range chunkFixtures {
+		b.Run(c.name, func(b *testing.B) {
+			ch := ChunkHeader{Size: uint32(len(c.body)) + ChunkHeaderSize, Type: c.typ}
+			b.SetBytes(int64(len(c.body)))
+			b.ReportAllocs()
+
+			for b.Loop() {
+				if _, err := fuzzLoader(c.body, ColorDepthRGBA).ParseChunk(ch, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, file := range benchmarkFiles {
+		b.Run(file.name, func(b *testing.B) {
+			p := PixelsZlib(pixelsFixture(file.size, 0))
+			b.SetBytes(int64(file.size * file.size * 4))
+			b.ReportAllocs()
+
+			for b.Loop() {
+				if _, err := p.Decompress(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSpriteSheet(b *testing.B) {
+	benchmarkSprites(b, func(b *testing.B, sprite *Sprite) {
+		for b.Loop() {
+			if _, err := sprite.File.SpriteSheet(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkRenderFrame(b *testing.B) {
+	benchmarkSprites(b, func(b *testing.B, sprite *Sprite) {
+		frame := 0
+		for b.Loop() {
+			if _, err := sprite.RenderFrame(frame, RenderOptions{}); err != nil {
+				b.Fatal(err)
+			}
+			frame = (frame + 1) % len(sprite.Frames)
+		}
+	})
+}
+
+// allocatedBytes returns how many bytes run allocates on average.
+func allocatedBytes(runs int, run func()) uint64 {
+	run()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for range runs {
+		run()
+	}
+	runtime.ReadMemStats(&after)
+
+	return (after.TotalAlloc - before.TotalAlloc) / uint64(runs)
+}
+
+// TestAllocationCeilings keeps the allocation counts and the allocated bytes
+// of the decode and render paths of a medium file under ceilings, so a
+// change that allocates per pixel or per chunk, or allocates a buffer far
+// larger than the images, shows up here. The ceilings leave room for what
+// changes between Go releases: byte ceilings are twice the images a path
+// has to allocate plus slack for buffers and zlib state.
+func TestAllocationCeilings(t *testing.T) {
+	data := animationFixture(16, 64)
+	ase, err := DeserializeFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	sprite, err := ase.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	// the pixels of every cel are the least a full decode allocates
+	pixels := uint64(16 * 64 * 64 * 4)
+	canvas := uint64(64 * 64 * 4)
+	compressed := PixelsZlib(pixelsFixture(64, 0))
+
+	tests := []struct {
+		name   string
+		allocs float64
+		bytes  uint64
+		run    func() error
+	}{
+		{"ParseHeader", 4, 1 << 10, func() error {
+			_, err := (&Loader{Buffer: bytes.NewBuffer(data[:HeaderSize])}).ParseHeader()
+			return err
+		}},
+		{"DeserializeFile", 500, 2*pixels + 256<<10, func() error {
+			_, err := DeserializeFile(bytes.NewReader(data))
+			return err
+		}},
+		{"Decompress", 32, 2*canvas + 128<<10, func() error {
+			_, err := compressed.Decompress()
+			return err
+		}},
+		{"RenderFrame", 10, 2*2*canvas + 16<<10, func() error {
+			_, err := sprite.RenderFrame(1, RenderOptions{})
+			return err
+		}},
+		{"SpriteSheet", 80, 2*2*canvas*16 + 128<<10, func() error {
+			_, err := ase.SpriteSheet()
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		if err := tt.run(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		run := func() { tt.run() }
+		if allocs := testing.AllocsPerRun(10, run); allocs > tt.allocs {
+			t.Errorf("%s: %v allocations, ceiling %v", tt.name, allocs, tt.allocs)
+		}
+		if n := allocatedBytes(10, run); n > tt.bytes {
+			t.Errorf("%s: %d bytes allocated, ceiling %d", tt.name, n, tt.bytes)
+		}
+	}
+}
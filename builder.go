@@ -0,0 +1,489 @@
+package ase
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+	"time"
+)
+
+var ErrInvalidSprite = errors.New("invalid sprite")
+
+// SpriteBuilder builds an AsepriteFile from code. The *Layer, *Tag, *Slice
+// and *Cel it returns are handles to pass back to it, Build turns them into
+// chunks with their sizes and counts filled in.
+type SpriteBuilder struct {
+	width, height int
+	depth         ColorDepth
+
+	// layers are kept in the order they were added, Build writes each
+	// group before its children
+	layers   []*Layer
+	frames   []*builderFrame
+	tags     []*Tag
+	slices   []*Slice
+	palette  Palette
+	userData *ChunkUserData
+}
+
+type builderFrame struct {
+	duration time.Duration
+	cels     []*Cel
+}
+
+// NewSprite starts a sprite of the given canvas size and color depth, with
+// no layers or frames.
+func NewSprite(width, height int, depth ColorDepth) *SpriteBuilder {
+	return &SpriteBuilder{width: width, height: height, depth: depth}
+}
+
+// AddLayer adds a visible, editable image layer on top of the layers of
+// parent, or of the sprite when parent is nil. The Index of the layer is
+// its place in the builder: Build writes each group before its children,
+// so the layer may have another index in the file.
+func (b *SpriteBuilder) AddLayer(name string, parent *Layer) *Layer {
+	return b.addLayer(name, parent, LayerTypeImage)
+}
+
+// AddGroup adds a group layer on top of the layers of parent, or of the
+// sprite when parent is nil.
+func (b *SpriteBuilder) AddGroup(name string, parent *Layer) *Layer {
+	return b.addLayer(name, parent, LayerTypeGroup)
+}
+
+func (b *SpriteBuilder) addLayer(name string, parent *Layer, typ uint16) *Layer {
+	layer := &Layer{
+		ChunkLayer: &ChunkLayer{
+			header:          ChunkHeader{Type: LayerChunkHex},
+			ChunkLayerData:  ChunkLayerData{Type: typ, Opacity: 0xFF},
+			ChunkLayerName:  ChunkLayerName(name),
+			ChunkLayerFlags: ChunkLayerFlags{Visible: true, Editable: true},
+		},
+		Index:  len(b.layers),
+		Parent: parent,
+	}
+	b.layers = append(b.layers, layer)
+
+	return layer
+}
+
+// AddFrame adds an empty frame shown for duration and returns its index.
+func (b *SpriteBuilder) AddFrame(duration time.Duration) int {
+	b.frames = append(b.frames, &builderFrame{duration: duration})
+	return len(b.frames) - 1
+}
+
+// SetCel places img on layer in frame, its top left corner at x, y on the
+// canvas, replacing the cel already there. Colors are converted to the
+// color depth of the sprite: the indexes of an *image.Paletted are kept
+// for indexed sprites, other images are mapped to the closest colors of the
+// palette, so SetPalette comes first.
+func (b *SpriteBuilder) SetCel(layer *Layer, frame int, img image.Image, x, y int) (*Cel, error) {
+	if !b.hasLayer(layer) || layer.IsGroup() {
+		return nil, fmt.Errorf("builder: %w (cel on a layer that is not an image layer of the sprite)", ErrInvalidSprite)
+	}
+
+	if frame < 0 || frame >= len(b.frames) {
+		return nil, fmt.Errorf("builder: %w (frame %d out of range [0, %d))", ErrInvalidSprite, frame, len(b.frames))
+	}
+
+	r := img.Bounds()
+	if r.Dx() > math.MaxUint16 || r.Dy() > math.MaxUint16 || x < math.MinInt16 || x > math.MaxInt16 || y < math.MinInt16 || y > math.MaxInt16 {
+		return nil, fmt.Errorf("builder: %w (cel of %dx%d at %d,%d)", ErrInvalidSprite, r.Dx(), r.Dy(), x, y)
+	}
+
+	pixels, err := b.pixels(img)
+	if err != nil {
+		return nil, err
+	}
+
+	cel := &Cel{
+		Chunk: &ChunkCelImage{
+			header:       ChunkHeader{Type: CelChunkHex},
+			ChunkCelData: ChunkCelData{X: int16(x), Y: int16(y), Opacity: 0xFF, CelType: CelTypeRawImage},
+			ChunkCelRawImageData: ChunkCelRawImageData{
+				ChunkCelDimensionData: ChunkCelDimensionData{Width: uint16(r.Dx()), Height: uint16(r.Dy())},
+				Pixels:                pixels,
+			},
+		},
+		Layer: layer,
+		Frame: frame,
+	}
+
+	f := b.frames[frame]
+	for i, c := range f.cels {
+		if c.Layer == layer {
+			f.cels[i] = cel
+			return cel, nil
+		}
+	}
+	f.cels = append(f.cels, cel)
+
+	return cel, nil
+}
+
+// pixels converts img to the pixels of a cel of the sprite.
+func (b *SpriteBuilder) pixels(img image.Image) (Pixels, error) {
+	r := img.Bounds()
+	rect := image.Rect(0, 0, r.Dx(), r.Dy())
+
+	switch b.depth {
+	case ColorDepthRGBA:
+		dst := image.NewNRGBA(rect)
+		draw.Draw(dst, rect, img, r.Min, draw.Src)
+		return dst, nil
+	case ColorDepthGrayscale:
+		dst := NewGrayAlpha(rect)
+		draw.Draw(dst, rect, img, r.Min, draw.Src)
+		return dst, nil
+	case ColorDepthIndexed:
+		if len(b.palette) == 0 {
+			return nil, fmt.Errorf("builder: %w (indexed cel before SetPalette)", ErrInvalidSprite)
+		}
+
+		colors := imagePalette(b.palette, 0)
+		if p, ok := img.(*image.Paletted); ok {
+			dst := image.NewPaletted(rect, colors)
+			for y := range rect.Dy() {
+				i := p.PixOffset(r.Min.X, r.Min.Y+y)
+				copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], p.Pix[i:i+rect.Dx()])
+			}
+			return dst, nil
+		}
+
+		src := image.NewNRGBA(rect)
+		draw.Draw(src, rect, img, r.Min, draw.Src)
+		return newIndexer(b.palette, 0).indexImage(src, DitherNone, colors), nil
+	default:
+		return nil, fmt.Errorf("builder: %w %d", ErrInvalidColorDepth, b.depth)
+	}
+}
+
+// AddTag tags the frames from through to, both included, played forward.
+func (b *SpriteBuilder) AddTag(name string, from, to int) (*Tag, error) {
+	if from < 0 || to < from || to >= len(b.frames) {
+		return nil, fmt.Errorf("builder: %w (tag %q from frame %d to %d of %d)", ErrInvalidSprite, name, from, to, len(b.frames))
+	}
+
+	tag := &Tag{ChunkTagEntry: ChunkTagEntry{
+		ChunkTagEntryData: ChunkTagEntryData{FromFrame: uint16(from), ToFrame: uint16(to), LoopAnimationType: LoopAnimationForward},
+		Name:              name,
+	}}
+	b.tags = append(b.tags, tag)
+
+	return tag, nil
+}
+
+// AddSlice adds a slice covering bounds of the canvas from the first frame
+// on.
+func (b *SpriteBuilder) AddSlice(name string, bounds image.Rectangle) (*Slice, error) {
+	bounds = bounds.Canon()
+	if bounds.Min.X < math.MinInt32 || bounds.Max.X > math.MaxInt32 || bounds.Min.Y < math.MinInt32 || bounds.Max.Y > math.MaxInt32 {
+		return nil, fmt.Errorf("builder: %w (slice %q at %v)", ErrInvalidSprite, name, bounds)
+	}
+
+	slice := &Slice{ChunkSlice: &ChunkSlice{
+		header: ChunkHeader{Type: SliceChunkHex},
+		Name:   name,
+		Keys: []ChunkSliceKey{{ChunkSliceKeyData: ChunkSliceKeyData{
+			OriginX: int32(bounds.Min.X),
+			OriginY: int32(bounds.Min.Y),
+			Width:   uint32(bounds.Dx()),
+			Height:  uint32(bounds.Dy()),
+		}}},
+	}}
+	b.slices = append(b.slices, slice)
+
+	return slice, nil
+}
+
+// SetPalette sets the palette of the sprite, of 1 to 256 colors. Entry 0 is
+// the transparent index of indexed sprites.
+func (b *SpriteBuilder) SetPalette(p Palette) error {
+	if len(p) == 0 || len(p) > 256 {
+		return fmt.Errorf("builder: %w (palette of %d colors)", ErrInvalidSprite, len(p))
+	}
+
+	b.palette = append(Palette(nil), p...)
+	return nil
+}
+
+// SetUserData sets the text and color of the user data of target: a *Layer,
+// *Tag, *Slice or *Cel of the sprite, or the sprite itself when target is
+// nil. c may be nil for user data without a color. The user data of the
+// sprite follows its palette, so it needs SetPalette.
+func (b *SpriteBuilder) SetUserData(target any, text string, c color.Color) error {
+	if len(text) > math.MaxUint16 {
+		return fmt.Errorf("builder: %w (user data text of %d bytes)", ErrInvalidSprite, len(text))
+	}
+
+	userData := &ChunkUserData{header: ChunkHeader{Type: UserDataChunkHex}, Text: text}
+	if c != nil {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		userData.Color = &ChunkUserDataColor{R: n.R, G: n.G, B: n.B, A: n.A}
+	}
+
+	switch t := target.(type) {
+	case nil:
+		b.userData = userData
+	case *Layer:
+		if !b.hasLayer(t) {
+			return fmt.Errorf("builder: %w (user data for a layer of another sprite)", ErrInvalidSprite)
+		}
+		t.UserData = userData
+	case *Tag:
+		if !slices.Contains(b.tags, t) {
+			return fmt.Errorf("builder: %w (user data for a tag of another sprite)", ErrInvalidSprite)
+		}
+		t.UserData = userData
+	case *Slice:
+		if !slices.Contains(b.slices, t) {
+			return fmt.Errorf("builder: %w (user data for a slice of another sprite)", ErrInvalidSprite)
+		}
+		t.UserData = userData
+	case *Cel:
+		if t.Frame < 0 || t.Frame >= len(b.frames) || !slices.Contains(b.frames[t.Frame].cels, t) {
+			return fmt.Errorf("builder: %w (user data for a cel that is not in the sprite)", ErrInvalidSprite)
+		}
+		t.UserData = userData
+	default:
+		return fmt.Errorf("builder: %w (user data for %T)", ErrInvalidSprite, target)
+	}
+
+	return nil
+}
+
+func (b *SpriteBuilder) hasLayer(layer *Layer) bool {
+	return layer != nil && layer.Index < len(b.layers) && b.layers[layer.Index] == layer
+}
+
+// Build returns the file of the sprite. Every chunk has its header, counts
+// and name lengths filled in, with sizes as the chunks take in a file, cels
+// being raw images; frame and file sizes add them up. The first frame holds
+// the palette, the layers, the tags and the slices, each followed by its
+// user data.
+func (b *SpriteBuilder) Build() (*AsepriteFile, error) {
+	if b.width <= 0 || b.height <= 0 || b.width > math.MaxUint16 || b.height > math.MaxUint16 {
+		return nil, fmt.Errorf("builder: %w (canvas of %dx%d)", ErrInvalidSprite, b.width, b.height)
+	}
+
+	if b.depth.BytesPerPixel() == 0 {
+		return nil, fmt.Errorf("builder: %w %d", ErrInvalidColorDepth, b.depth)
+	}
+
+	if len(b.frames) == 0 || len(b.frames) > math.MaxUint16 {
+		return nil, fmt.Errorf("builder: %w (%d frames)", ErrInvalidSprite, len(b.frames))
+	}
+
+	if b.userData != nil && len(b.palette) == 0 {
+		return nil, fmt.Errorf("builder: %w (sprite user data without a palette)", ErrInvalidSprite)
+	}
+
+	ase := &AsepriteFile{Header: Header{
+		MagicNumber:  0xA5E0,
+		Frames:       uint16(len(b.frames)),
+		Width:        uint16(b.width),
+		Height:       uint16(b.height),
+		ColorDepth:   b.depth,
+		Flags:        HeaderFlagLayerOpacityValid | HeaderFlagGroupOpacityValid,
+		FrameSpeed:   100,
+		NumberColors: uint16(len(b.palette)),
+		PixelWidth:   1,
+		PixelHeight:  1,
+		GridWidth:    16,
+		GridHeight:   16,
+	}}
+
+	for _, layer := range b.layers {
+		if layer.Parent != nil && (!b.hasLayer(layer.Parent) || !layer.Parent.IsGroup()) {
+			return nil, fmt.Errorf("builder: %w (layer %q in a parent that is not a group of the sprite)", ErrInvalidSprite, layer.Name())
+		}
+	}
+
+	// names are written after their 16-bit length
+	names := make([]string, 0, len(b.layers)+len(b.tags)+len(b.slices)+len(b.palette))
+	for _, layer := range b.layers {
+		names = append(names, layer.Name())
+	}
+	for _, tag := range b.tags {
+		names = append(names, tag.Name)
+	}
+	for _, slice := range b.slices {
+		names = append(names, slice.Name)
+	}
+	for _, e := range b.palette {
+		names = append(names, e.Name)
+	}
+	for _, name := range names {
+		if len(name) > math.MaxUint16 {
+			return nil, fmt.Errorf("builder: %w (name of %d bytes)", ErrInvalidSprite, len(name))
+		}
+	}
+
+	layers := b.layerOrder()
+	index := make(map[*Layer]int, len(layers))
+	for i, layer := range layers {
+		index[layer] = i
+	}
+
+	fileSize := uint32(HeaderSize)
+	for i, f := range b.frames {
+		duration := f.duration.Milliseconds()
+		if duration < 0 || duration > math.MaxUint16 {
+			return nil, fmt.Errorf("builder: %w (frame %d lasts %v)", ErrInvalidSprite, i, f.duration)
+		}
+
+		var chunks []Chunk
+		if i == 0 {
+			chunks = b.firstFrameChunks(layers)
+		}
+
+		// cels are written in layer order, like Aseprite does
+		cels := slices.Clone(f.cels)
+		slices.SortStableFunc(cels, func(a, b *Cel) int {
+			return cmp.Compare(index[a.Layer], index[b.Layer])
+		})
+
+		for _, cel := range cels {
+			c := *cel.Chunk.(*ChunkCelImage)
+			c.LayerIndex = uint16(index[cel.Layer])
+			c.header.Size = ChunkHeaderSize + ChunkCelDataSize + ChunkCelDimensionSize + uint32(int(c.Width)*int(c.Height)*b.depth.BytesPerPixel())
+			chunks = append(chunks, &c)
+			chunks = appendUserData(chunks, cel.UserData)
+		}
+
+		frameSize := uint32(FrameHeaderSize)
+		for _, c := range chunks {
+			frameSize += c.GetHeader().Size
+		}
+
+		header := FrameHeader{
+			FrameBytes:     frameSize,
+			MagicNumber:    0xF1FA,
+			OldChunkNumber: uint16(min(len(chunks), 0xFFFF)),
+			FrameDuration:  uint16(duration),
+			ChunkNumber:    uint32(len(chunks)),
+		}
+		ase.Frames = append(ase.Frames, Frame{Header: header, Chunks: chunks})
+		fileSize += frameSize
+	}
+	ase.Header.FileSize = fileSize
+
+	return ase, nil
+}
+
+// layerOrder returns the layers in file order: each group followed by its
+// children, in the order they were added.
+func (b *SpriteBuilder) layerOrder() []*Layer {
+	children := make(map[*Layer][]*Layer)
+	for _, layer := range b.layers {
+		children[layer.Parent] = append(children[layer.Parent], layer)
+	}
+
+	var out []*Layer
+	var walk func(parent *Layer)
+	walk = func(parent *Layer) {
+		for _, layer := range children[parent] {
+			out = append(out, layer)
+			walk(layer)
+		}
+	}
+	walk(nil)
+
+	return out
+}
+
+// firstFrameChunks returns the chunks that describe the whole sprite.
+func (b *SpriteBuilder) firstFrameChunks(layers []*Layer) []Chunk {
+	var chunks []Chunk
+
+	if len(b.palette) > 0 {
+		chunks = append(chunks, newPaletteChunk(b.palette))
+		chunks = appendUserData(chunks, b.userData)
+	}
+
+	for _, layer := range layers {
+		c := *layer.ChunkLayer
+		c.ChunkLayerData.NameLength = uint16(len(c.ChunkLayerName))
+		c.ChunkLayerData.FlagsBit = c.ChunkLayerFlags.bits()
+		c.ChunkLayerData.ChildLevel = 0
+		for parent := layer.Parent; parent != nil; parent = parent.Parent {
+			c.ChunkLayerData.ChildLevel++
+		}
+		c.header.Size = ChunkHeaderSize + ChunkLayerDataSize + uint32(len(c.ChunkLayerName))
+		chunks = append(chunks, &c)
+		chunks = appendUserData(chunks, layer.UserData)
+	}
+
+	if len(b.tags) > 0 {
+		tags := &ChunkTag{header: ChunkHeader{Type: TagsChunkHex, Size: ChunkHeaderSize + ChunkTagDataSize}}
+		// user data goes to the tags in turn, tags before the last one that
+		// has some get an empty one
+		last := -1
+		for i, tag := range b.tags {
+			entry := tag.ChunkTagEntry
+			entry.TagNameSize = uint16(len(entry.Name))
+			tags.Entries = append(tags.Entries, entry)
+			tags.header.Size += ChunkTagEntryDataSize + uint32(len(entry.Name))
+			if tag.UserData != nil {
+				last = i
+			}
+		}
+		chunks = append(chunks, tags)
+
+		for _, tag := range b.tags[:last+1] {
+			userData := tag.UserData
+			if userData == nil {
+				userData = &ChunkUserData{header: ChunkHeader{Type: UserDataChunkHex}}
+			}
+			chunks = appendUserData(chunks, userData)
+		}
+	}
+
+	for _, slice := range b.slices {
+		c := *slice.ChunkSlice
+		c.NumberSliceKeys = uint32(len(c.Keys))
+		c.NameLength = uint16(len(c.Name))
+		c.header.Size = ChunkHeaderSize + ChunkSliceDataSize + uint32(len(c.Name)) + uint32(len(c.Keys))*ChunkSliceKeyDataSize
+		chunks = append(chunks, &c)
+		chunks = appendUserData(chunks, slice.UserData)
+	}
+
+	return chunks
+}
+
+// appendUserData appends a copy of userData with its size filled in, if
+// there is one.
+func appendUserData(chunks []Chunk, userData *ChunkUserData) []Chunk {
+	if userData == nil {
+		return chunks
+	}
+
+	c := *userData
+	c.header = ChunkHeader{Type: UserDataChunkHex, Size: ChunkHeaderSize + UserDataFlagSize}
+	if c.Text != "" {
+		c.header.Size += 2 + uint32(len(c.Text))
+	}
+	if c.Color != nil {
+		c.header.Size += ChunkUserDataColorSize
+	}
+
+	return append(chunks, &c)
+}
+
+// bits returns the flags as the FlagsBit of ChunkLayerData.
+func (f ChunkLayerFlags) bits() uint16 {
+	var bits uint16
+	for i, set := range []bool{f.Visible, f.Editable, f.LockMovement, f.Background, f.PreferLinkedCels, f.LayerGroupDisplayCollapsed, f.ReferenceLayer} {
+		if set {
+			bits |= 1 << i
+		}
+	}
+
+	return bits
+}
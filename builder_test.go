@@ -0,0 +1,338 @@
+package ase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// encodeFile writes the chunks a SpriteBuilder makes as they are laid out in
+// a file, trusting the sizes and counts Build filled in.
+func encodeFile(t *testing.T, ase *AsepriteFile) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	w := func(v any) {
+		if err := binary.Write(&out, binary.LittleEndian, v); err != nil {
+			t.Fatalf("failed to encode %T: %v", v, err)
+		}
+	}
+	name := func(s string) {
+		w(uint16(len(s)))
+		out.WriteString(s)
+	}
+
+	w(ase.Header)
+	for _, frame := range ase.Frames {
+		w(frame.Header)
+		for _, chunk := range frame.Chunks {
+			w(chunk.GetHeader())
+			switch c := chunk.(type) {
+			case *ChunkLayer:
+				w(c.ChunkLayerData)
+				out.WriteString(string(c.ChunkLayerName))
+			case *ChunkPalette:
+				w(c.ChunkPaletteData)
+				for _, e := range c.Entries {
+					data := ChunkPaletteEntryData{Red: e.Red, Green: e.Green, Blue: e.Blue, Alpha: e.Alpha}
+					if e.ColorName != "" {
+						data.HasName = 1
+					}
+					w(data)
+					if e.ColorName != "" {
+						name(e.ColorName)
+					}
+				}
+			case *ChunkUserData:
+				var flags UserDataFlag
+				if c.Text != "" {
+					flags |= UserDataHasText
+				}
+				if c.Color != nil {
+					flags |= UserDataHasColor
+				}
+				w(flags)
+				if c.Text != "" {
+					name(c.Text)
+				}
+				if c.Color != nil {
+					w(*c.Color)
+				}
+			case *ChunkTag:
+				w(ChunkTagData{NumberTags: uint16(len(c.Entries))})
+				for _, e := range c.Entries {
+					w(e.ChunkTagEntryData)
+					out.WriteString(e.Name)
+				}
+			case *ChunkSlice:
+				w(c.ChunkSliceData)
+				out.WriteString(c.Name)
+				for _, key := range c.Keys {
+					w(key.ChunkSliceKeyData)
+				}
+			case *ChunkCelImage:
+				w(c.ChunkCelData)
+				w(c.ChunkCelDimensionData)
+				switch p := c.Pixels.(type) {
+				case *image.NRGBA:
+					out.Write(p.Pix)
+				case *GrayAlpha:
+					out.Write(p.Pix)
+				case *image.Paletted:
+					out.Write(p.Pix)
+				}
+			default:
+				t.Fatalf("unexpected chunk %T", chunk)
+			}
+		}
+	}
+
+	return out.Bytes()
+}
+
+// builderSprite builds a sprite of the given depth with a group holding a
+// layer, a top level layer, two frames, tags, a slice and user data.
+func builderSprite(t *testing.T, depth ColorDepth) *SpriteBuilder {
+	t.Helper()
+
+	b := NewSprite(4, 3, depth)
+	palette := Palette{
+		{NRGBA: color.NRGBA{}},
+		{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}, Name: "red"},
+		{NRGBA: color.NRGBA{G: 0xFF, A: 0xFF}},
+		{NRGBA: color.NRGBA{B: 0xFF, A: 0xFF}, Name: "blue"},
+	}
+	if err := b.SetPalette(palette); err != nil {
+		t.Fatalf("failed to set the palette: %v", err)
+	}
+
+	body := b.AddGroup("body", nil)
+	bg := b.AddLayer("bg", nil)
+	arm := b.AddLayer("arm", body)
+	b.AddFrame(100 * time.Millisecond)
+	b.AddFrame(250 * time.Millisecond)
+
+	// bg is above the group, so it only covers the top row
+	fill := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	for i := range fill.Pix {
+		fill.Pix[i] = 0xFF
+	}
+	if _, err := b.SetCel(bg, 0, fill, 0, 0); err != nil {
+		t.Fatalf("failed to set the bg cel: %v", err)
+	}
+
+	for frame := range 2 {
+		// a blue pixel next to a red one, offset from the image origin
+		img := image.NewPaletted(image.Rect(5, 5, 7, 6), palette.ColorPalette())
+		img.Pix[0], img.Pix[1] = 3, 1
+		cel, err := b.SetCel(arm, frame, img, frame, 1)
+		if err != nil {
+			t.Fatalf("failed to set the arm cel of frame %d: %v", frame, err)
+		}
+		if frame == 1 {
+			if err := b.SetUserData(cel, "swing", nil); err != nil {
+				t.Fatalf("failed to set the cel user data: %v", err)
+			}
+		}
+	}
+
+	if _, err := b.AddTag("idle", 0, 1); err != nil {
+		t.Fatalf("failed to add a tag: %v", err)
+	}
+	walk, err := b.AddTag("walk", 1, 1)
+	if err != nil {
+		t.Fatalf("failed to add a tag: %v", err)
+	}
+	slice, err := b.AddSlice("hit", image.Rect(3, 2, 1, 0))
+	if err != nil {
+		t.Fatalf("failed to add a slice: %v", err)
+	}
+
+	for target, text := range map[any]string{nil: "sprite", body: "group", walk: "walk", slice: "slice"} {
+		if err := b.SetUserData(target, text, color.NRGBA{R: 1, G: 2, B: 3, A: 4}); err != nil {
+			t.Fatalf("failed to set the user data of %T: %v", target, err)
+		}
+	}
+
+	return b
+}
+
+func TestSpriteBuilder(t *testing.T) {
+	for _, depth := range []ColorDepth{ColorDepthRGBA, ColorDepthGrayscale, ColorDepthIndexed} {
+		built, err := builderSprite(t, depth).Build()
+		if err != nil {
+			t.Fatalf("depth %d: failed to build: %v", depth, err)
+		}
+
+		data := encodeFile(t, built)
+		if len(data) != int(built.Header.FileSize) {
+			t.Fatalf("depth %d: encoded %d bytes, header says %d", depth, len(data), built.Header.FileSize)
+		}
+
+		decoded, err := Decode(bytes.NewReader(data), DecodeOptions{Strict: true})
+		if err != nil {
+			t.Fatalf("depth %d: failed to decode: %v", depth, err)
+		}
+
+		if decoded.Header != built.Header {
+			t.Errorf("depth %d: header %+v, built %+v", depth, decoded.Header, built.Header)
+		}
+		for i := range built.Frames {
+			if decoded.Frames[i].Header != built.Frames[i].Header {
+				t.Errorf("depth %d: frame %d header %+v, built %+v", depth, i, decoded.Frames[i].Header, built.Frames[i].Header)
+			}
+			for j, chunk := range built.Frames[i].Chunks {
+				if got := decoded.Frames[i].Chunks[j].GetHeader(); got != chunk.GetHeader() {
+					t.Errorf("depth %d: frame %d chunk %d header %+v, built %+v", depth, i, j, got, chunk.GetHeader())
+				}
+			}
+		}
+
+		want, err := built.Sprite()
+		if err != nil {
+			t.Fatalf("depth %d: failed to build the sprite of the built file: %v", depth, err)
+		}
+		sprite, err := decoded.Sprite()
+		if err != nil {
+			t.Fatalf("depth %d: failed to build the sprite: %v", depth, err)
+		}
+
+		var layers []string
+		for _, layer := range sprite.Layers {
+			layers = append(layers, layer.Name())
+		}
+		if !reflect.DeepEqual(layers, []string{"body", "arm", "bg"}) {
+			t.Errorf("depth %d: layers %v", depth, layers)
+		}
+		if sprite.Layers[1].Parent != sprite.Layers[0] || sprite.Layers[2].Parent != nil {
+			t.Errorf("depth %d: arm should be in body, bg at the top level", depth)
+		}
+		if cels := sprite.Frames[0].Cels; cels[0].Layer != sprite.Layers[1] || cels[1].Layer != sprite.Layers[2] {
+			t.Errorf("depth %d: expected the cels of frame 0 in layer order", depth)
+		}
+
+		userData := map[string]*ChunkUserData{
+			"sprite": sprite.UserData,
+			"group":  sprite.Layers[0].UserData,
+			"walk":   sprite.Tags[1].UserData,
+			"slice":  sprite.Slices[0].UserData,
+		}
+		for text, ud := range userData {
+			if ud == nil || ud.Text != text || ud.Color == nil || *ud.Color != (ChunkUserDataColor{1, 2, 3, 4}) {
+				t.Errorf("depth %d: user data %q is %+v", depth, text, ud)
+			}
+		}
+		if ud := sprite.Frames[1].Cel(sprite.Layers[1]).UserData; ud == nil || ud.Text != "swing" || ud.Color != nil {
+			t.Errorf("depth %d: cel user data is %+v", depth, ud)
+		}
+		if ud := sprite.Tags[0].UserData; ud != nil && (ud.Text != "" || ud.Color != nil) {
+			t.Errorf("depth %d: expected no user data on the first tag, got %+v", depth, ud)
+		}
+
+		if len(sprite.Tags) != 2 || sprite.Tags[0].Name != "idle" || sprite.Tags[1].FromFrame != 1 || sprite.Tags[1].ToFrame != 1 {
+			t.Errorf("depth %d: tags %+v", depth, sprite.Tags)
+		}
+		key := sprite.Slices[0].Keys[0]
+		if sprite.Slices[0].Name != "hit" || key.OriginX != 1 || key.OriginY != 0 || key.Width != 2 || key.Height != 2 {
+			t.Errorf("depth %d: slice %+v", depth, sprite.Slices[0])
+		}
+		if !reflect.DeepEqual(sprite.Palette, want.Palette) || sprite.Palette[3].Name != "blue" {
+			t.Errorf("depth %d: palette %v", depth, sprite.Palette)
+		}
+		if sprite.Frames[1].Duration != 250*time.Millisecond {
+			t.Errorf("depth %d: frame 1 lasts %v", depth, sprite.Frames[1].Duration)
+		}
+
+		for i := range sprite.Frames {
+			img, err := sprite.RenderFrame(i, RenderOptions{})
+			if err != nil {
+				t.Fatalf("depth %d: failed to render frame %d: %v", depth, i, err)
+			}
+			wantImg, err := want.RenderFrame(i, RenderOptions{})
+			if err != nil {
+				t.Fatalf("depth %d: failed to render built frame %d: %v", depth, i, err)
+			}
+			if !reflect.DeepEqual(img, wantImg) {
+				t.Errorf("depth %d: frame %d renders differently once decoded", depth, i)
+			}
+
+			// the arm cel is not under the background
+			red, blue := color.RGBA{R: 0xFF, A: 0xFF}, color.RGBA{B: 0xFF, A: 0xFF}
+			if depth == ColorDepthGrayscale {
+				red, blue = color.RGBA{R: 0x4C, G: 0x4C, B: 0x4C, A: 0xFF}, color.RGBA{R: 0x1D, G: 0x1D, B: 0x1D, A: 0xFF}
+			}
+			if i == 0 && (img.RGBAAt(0, 1) != blue || img.RGBAAt(1, 1) != red) {
+				t.Errorf("depth %d: frame 0 has %v and %v at the arm cel", depth, img.RGBAAt(0, 1), img.RGBAAt(1, 1))
+			}
+		}
+	}
+}
+
+func TestSpriteBuilderErrors(t *testing.T) {
+	b := NewSprite(4, 4, ColorDepthIndexed)
+	group := b.AddGroup("group", nil)
+	layer := b.AddLayer("layer", group)
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+
+	if _, err := b.Build(); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite without frames, got %v", err)
+	}
+
+	b.AddFrame(0)
+	if _, err := b.SetCel(layer, 0, img, 0, 0); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for an indexed cel before SetPalette, got %v", err)
+	}
+	if err := b.SetUserData(nil, "sprite", nil); err != nil {
+		t.Fatalf("failed to set the sprite user data: %v", err)
+	}
+	if _, err := b.Build(); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for sprite user data without a palette, got %v", err)
+	}
+
+	if err := b.SetPalette(nil); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for an empty palette, got %v", err)
+	}
+	if err := b.SetPalette(Palette{{}, {NRGBA: color.NRGBA{A: 0xFF}}}); err != nil {
+		t.Fatalf("failed to set the palette: %v", err)
+	}
+
+	if _, err := b.SetCel(group, 0, img, 0, 0); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for a cel on a group, got %v", err)
+	}
+	if _, err := b.SetCel(layer, 1, img, 0, 0); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for a frame out of range, got %v", err)
+	}
+	if _, err := b.SetCel(NewSprite(1, 1, ColorDepthRGBA).AddLayer("other", nil), 0, img, 0, 0); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for a layer of another sprite, got %v", err)
+	}
+	if _, err := b.AddTag("tag", 0, 1); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for a tag past the last frame, got %v", err)
+	}
+	if err := b.SetUserData(img, "image", nil); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for user data on an image, got %v", err)
+	}
+
+	other := builderSprite(t, ColorDepthRGBA)
+	otherTag, _ := other.AddTag("other", 0, 0)
+	otherSlice, _ := other.AddSlice("other", image.Rect(0, 0, 1, 1))
+	otherCel, _ := other.SetCel(other.layers[2], 0, img, 0, 0)
+	for _, target := range []any{otherTag, otherSlice, otherCel} {
+		if err := b.SetUserData(target, "other", nil); !errors.Is(err, ErrInvalidSprite) {
+			t.Errorf("expected ErrInvalidSprite for user data on a %T of another sprite, got %v", target, err)
+		}
+	}
+
+	b.AddLayer("orphan", layer)
+	if _, err := b.Build(); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for a layer in an image layer, got %v", err)
+	}
+
+	if _, err := NewSprite(0, 1, ColorDepthRGBA).Build(); !errors.Is(err, ErrInvalidSprite) {
+		t.Errorf("expected ErrInvalidSprite for an empty canvas, got %v", err)
+	}
+}
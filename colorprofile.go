@@ -0,0 +1,328 @@
+package ase
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+var ErrUnsupportedColorProfile = errors.New("unsupported color profile")
+
+// ColorProfileFlagFixedGamma marks an sRGB profile that uses the fixed gamma
+// in ChunkColorProfileData.Gamma instead of the sRGB curve.
+const ColorProfileFlagFixedGamma uint16 = 1 << 0
+
+type ColorManagement int
+
+const (
+	// ColorManagementSRGB converts colors from the sprite color profile to
+	// sRGB.
+	ColorManagementSRGB ColorManagement = iota
+	// ColorManagementNone passes colors through untouched.
+	ColorManagementNone
+)
+
+// ICCProfile is the part of an ICC v2/v4 RGB matrix/TRC profile needed to
+// convert its colors into the D50 XYZ connection space.
+type ICCProfile struct {
+	Version uint32
+	// ToXYZ maps linear RGB to D50 XYZ, one column per colorant.
+	ToXYZ [3][3]float64
+	// TRC holds the red, green and blue tone curves, mapping encoded values
+	// in [0, 1] to linear ones.
+	TRC [3]func(float64) float64
+}
+
+const iccHeaderSize = 128
+
+// ParseICCProfile reads an RGB matrix/TRC profile. Profiles built from
+// lookup tables and non RGB profiles fail with ErrUnsupportedColorProfile.
+func ParseICCProfile(data []byte) (*ICCProfile, error) {
+	if len(data) < iccHeaderSize+4 {
+		return nil, fmt.Errorf("icc: %w (profile too short)", ErrInvalidChunkSize)
+	}
+
+	if space := string(data[16:20]); space != "RGB " {
+		return nil, fmt.Errorf("icc: %w (color space %q)", ErrUnsupportedColorProfile, space)
+	}
+
+	if pcs := string(data[20:24]); pcs != "XYZ " {
+		return nil, fmt.Errorf("icc: %w (connection space %q)", ErrUnsupportedColorProfile, pcs)
+	}
+
+	tags := map[string][]byte{}
+	count := binary.BigEndian.Uint32(data[iccHeaderSize:])
+	if uint64(count)*12 > uint64(len(data)-iccHeaderSize-4) {
+		return nil, fmt.Errorf("icc: %w (%d tags)", ErrInvalidChunkSize, count)
+	}
+
+	for i := range int(count) {
+		entry := data[iccHeaderSize+4+i*12:]
+		offset := uint64(binary.BigEndian.Uint32(entry[4:]))
+		size := uint64(binary.BigEndian.Uint32(entry[8:]))
+		if offset+size > uint64(len(data)) {
+			return nil, fmt.Errorf("icc: %w (tag %q out of bounds)", ErrInvalidChunkSize, entry[:4])
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	p := &ICCProfile{Version: binary.BigEndian.Uint32(data[8:])}
+
+	for i, prefix := range []string{"r", "g", "b"} {
+		xyz, ok := tags[prefix+"XYZ"]
+		if !ok {
+			return nil, fmt.Errorf("icc: %w (missing %sXYZ tag)", ErrUnsupportedColorProfile, prefix)
+		}
+
+		column, err := parseICCXYZ(xyz)
+		if err != nil {
+			return nil, err
+		}
+
+		for row := range 3 {
+			p.ToXYZ[row][i] = column[row]
+		}
+
+		trc, ok := tags[prefix+"TRC"]
+		if !ok {
+			return nil, fmt.Errorf("icc: %w (missing %sTRC tag)", ErrUnsupportedColorProfile, prefix)
+		}
+
+		p.TRC[i], err = parseICCCurve(trc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func iccFixed(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func parseICCXYZ(b []byte) ([3]float64, error) {
+	if len(b) < 20 || string(b[:4]) != "XYZ " {
+		return [3]float64{}, fmt.Errorf("icc: %w (bad XYZ tag)", ErrUnsupportedColorProfile)
+	}
+
+	return [3]float64{iccFixed(b[8:]), iccFixed(b[12:]), iccFixed(b[16:])}, nil
+}
+
+func parseICCCurve(b []byte) (func(float64) float64, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("icc: %w (curve too short)", ErrInvalidChunkSize)
+	}
+
+	switch string(b[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(b[8:]))
+		if count > (len(b)-12)/2 {
+			return nil, fmt.Errorf("icc: %w (curve with %d entries)", ErrInvalidChunkSize, count)
+		}
+
+		switch count {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(b[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(b[12+i*2:])) / 65535
+		}
+
+		return func(x float64) float64 {
+			pos := min(max(x, 0), 1) * float64(count-1)
+			i := min(int(pos), count-2)
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+	case "para":
+		fn := binary.BigEndian.Uint16(b[8:])
+		params := []int{1, 3, 4, 5, 7}
+		if int(fn) >= len(params) || len(b) < 12+params[fn]*4 {
+			return nil, fmt.Errorf("icc: %w (parametric curve type %d)", ErrUnsupportedColorProfile, fn)
+		}
+
+		// every type is a special case of
+		// Y = (aX+b)^g + e for X >= d, Y = cX + f otherwise
+		var p [7]float64
+		for i := range params[fn] {
+			p[i] = iccFixed(b[12+i*4:])
+		}
+
+		g, a, offset, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch fn {
+		case 0:
+			a = 1
+		case 1, 2:
+			if a == 0 {
+				return nil, fmt.Errorf("icc: %w (parametric curve with a = 0)", ErrUnsupportedColorProfile)
+			}
+			d = -offset / a
+			if fn == 2 {
+				e, f = c, c
+			}
+			c = 0
+		}
+
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(max(a*x+offset, 0), g) + e
+			}
+			return c*x + f
+		}, nil
+	}
+
+	return nil, fmt.Errorf("icc: %w (curve type %q)", ErrUnsupportedColorProfile, b[:4])
+}
+
+// xyzD50ToSRGB maps D50 XYZ to linear sRGB, using the Bradford adapted sRGB
+// primaries.
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// colorConverter turns 8-bit non premultiplied RGB from a source profile
+// into sRGB.
+type colorConverter struct {
+	// decode holds the linear value of every 8-bit input, per channel
+	decode [3][256]float64
+	matrix [3][3]float64
+	// lut is used instead of decode and matrix when the channels don't mix
+	lut    *[3][256]uint8
+	encode [4096]uint8
+}
+
+func newColorConverter(trc [3]func(float64) float64, matrix *[3][3]float64) *colorConverter {
+	c := &colorConverter{}
+
+	if matrix == nil {
+		c.lut = new([3][256]uint8)
+		for ch := range 3 {
+			for i := range 256 {
+				c.lut[ch][i] = toByte(srgbEncode(trc[ch](float64(i) / 255)))
+			}
+		}
+		return c
+	}
+
+	c.matrix = *matrix
+	for ch := range 3 {
+		for i := range 256 {
+			c.decode[ch][i] = trc[ch](float64(i) / 255)
+		}
+	}
+	for i := range c.encode {
+		c.encode[i] = toByte(srgbEncode(float64(i) / float64(len(c.encode)-1)))
+	}
+
+	return c
+}
+
+// clamp01 limits v to [0, 1], mapping NaN to 0.
+func clamp01(v float64) float64 {
+	if !(v > 0) {
+		return 0
+	}
+	return min(v, 1)
+}
+
+func toByte(v float64) uint8 {
+	return uint8(math.Round(clamp01(v) * 255))
+}
+
+// convert rewrites the colors of NRGBA pixel data in place.
+func (c *colorConverter) convert(pix []uint8) {
+	for i := 0; i+3 < len(pix); i += 4 {
+		if c.lut != nil {
+			pix[i], pix[i+1], pix[i+2] = c.lut[0][pix[i]], c.lut[1][pix[i+1]], c.lut[2][pix[i+2]]
+			continue
+		}
+
+		r, g, b := c.decode[0][pix[i]], c.decode[1][pix[i+1]], c.decode[2][pix[i+2]]
+		for ch := range 3 {
+			v := c.matrix[ch][0]*r + c.matrix[ch][1]*g + c.matrix[ch][2]*b
+			pix[i+ch] = c.encode[int(clamp01(v)*float64(len(c.encode)-1)+0.5)]
+		}
+	}
+}
+
+// srgbCache keeps the converter of a color profile, which RenderFrame would
+// otherwise parse again for every frame.
+type srgbCache struct {
+	mu      sync.Mutex
+	built   bool
+	profile Chunk
+	conv    *colorConverter
+	err     error
+}
+
+// srgbConverter returns the converter from the sprite color profile to
+// sRGB, or nil when colors are already sRGB. Sprites built by
+// AsepriteFile.Sprite build it once per profile.
+func (s *Sprite) srgbConverter() (*colorConverter, error) {
+	c := s.srgb
+	if c == nil {
+		return s.newSRGBConverter()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.built || c.profile != s.ColorProfile {
+		c.built, c.profile = true, s.ColorProfile
+		c.conv, c.err = s.newSRGBConverter()
+	}
+
+	return c.conv, c.err
+}
+
+func (s *Sprite) newSRGBConverter() (*colorConverter, error) {
+	switch profile := s.ColorProfile.(type) {
+	case *ChunkColorProfileICC:
+		icc, err := ParseICCProfile(profile.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		var m [3][3]float64
+		for i := range 3 {
+			for j := range 3 {
+				for k := range 3 {
+					m[i][j] += xyzD50ToSRGB[i][k] * icc.ToXYZ[k][j]
+				}
+			}
+		}
+
+		return newColorConverter(icc.TRC, &m), nil
+	case *ChunkColorProfile:
+		if profile.Type != ColorProfileSRGB || profile.Flags&ColorProfileFlagFixedGamma == 0 {
+			return nil, nil
+		}
+
+		gamma := profile.Gamma.FixedToFloat()
+		if gamma <= 0 {
+			return nil, fmt.Errorf("colorprofile: %w (gamma %v)", ErrUnsupportedColorProfile, gamma)
+		}
+
+		// a fixed gamma keeps the sRGB primaries, only the curve changes
+		decode := func(x float64) float64 { return math.Pow(x, gamma) }
+		return newColorConverter([3]func(float64) float64{decode, decode, decode}, nil), nil
+	}
+
+	return nil, nil
+}
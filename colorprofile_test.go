@@ -0,0 +1,219 @@
+package ase
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// iccProfileFixture builds an RGB matrix/TRC profile from D50 colorants, one
+// per row, sharing the same tone curve tag for the three channels.
+func iccProfileFixture(colorants [3][3]float64, trc []byte) []byte {
+	fixed := func(f float64) []byte {
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(f*65536)))
+	}
+
+	var tagData [][]byte
+	for _, c := range colorants {
+		xyz := append([]byte("XYZ \x00\x00\x00\x00"), fixed(c[0])...)
+		xyz = append(xyz, fixed(c[1])...)
+		tagData = append(tagData, append(xyz, fixed(c[2])...))
+	}
+	tagData = append(tagData, trc)
+
+	profile := make([]byte, iccHeaderSize)
+	binary.BigEndian.PutUint32(profile[8:], 0x04200000)
+	copy(profile[12:], "mntr")
+	copy(profile[16:], "RGB ")
+	copy(profile[20:], "XYZ ")
+	copy(profile[36:], "acsp")
+
+	signatures := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	profile = binary.BigEndian.AppendUint32(profile, uint32(len(signatures)))
+
+	offset := len(profile) + len(signatures)*12
+	offsets := make([]int, len(tagData))
+	for i, data := range tagData {
+		offsets[i] = offset
+		offset += len(data)
+	}
+
+	for i, sig := range signatures {
+		data := min(i, len(tagData)-1)
+		profile = append(profile, sig...)
+		profile = binary.BigEndian.AppendUint32(profile, uint32(offsets[data]))
+		profile = binary.BigEndian.AppendUint32(profile, uint32(len(tagData[data])))
+	}
+
+	for _, data := range tagData {
+		profile = append(profile, data...)
+	}
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+
+	return profile
+}
+
+func iccParaFixture(params ...float64) []byte {
+	fn := map[int]uint16{1: 0, 3: 1, 4: 2, 5: 3, 7: 4}[len(params)]
+	para := append([]byte("para\x00\x00\x00\x00"), byte(fn>>8), byte(fn), 0, 0)
+	for _, p := range params {
+		para = binary.BigEndian.AppendUint32(para, uint32(int32(p*65536)))
+	}
+	return para
+}
+
+var (
+	srgbColorants = [3][3]float64{
+		{0.4360747, 0.2225045, 0.0139322},
+		{0.3850649, 0.7168786, 0.0971045},
+		{0.1430804, 0.0606169, 0.7141733},
+	}
+	displayP3Colorants = [3][3]float64{
+		{0.5151, 0.2412, -0.0011},
+		{0.2920, 0.6922, 0.0419},
+		{0.1571, 0.0666, 0.7841},
+	}
+	srgbCurveFixture = iccParaFixture(2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+)
+
+func convertColor(t *testing.T, profile []byte, c [3]uint8) [3]uint8 {
+	t.Helper()
+
+	sprite := &Sprite{ColorProfile: &ChunkColorProfileICC{
+		ChunkColorProfile:        ChunkColorProfile{ChunkColorProfileData: ChunkColorProfileData{Type: ColorProfileICC}},
+		ChunkColorProfileICCData: ChunkColorProfileICCData{DataLength: uint32(len(profile)), Data: profile},
+	}}
+
+	conv, err := sprite.srgbConverter()
+	if err != nil {
+		t.Fatalf("failed to build converter: %v", err)
+	}
+
+	pix := []uint8{c[0], c[1], c[2], 0xFF}
+	conv.convert(pix)
+
+	return [3]uint8{pix[0], pix[1], pix[2]}
+}
+
+func TestICCProfile(t *testing.T) {
+	near := func(a, b uint8) bool {
+		return a-b <= 1 || b-a <= 1
+	}
+
+	srgb := iccProfileFixture(srgbColorants, srgbCurveFixture)
+	for _, c := range [][3]uint8{{0, 0, 0}, {255, 255, 255}, {128, 128, 128}, {200, 30, 90}, {10, 250, 120}} {
+		got := convertColor(t, srgb, c)
+		if !near(got[0], c[0]) || !near(got[1], c[1]) || !near(got[2], c[2]) {
+			t.Errorf("sRGB profile: %v converted to %v, want it untouched", c, got)
+		}
+	}
+
+	p3 := iccProfileFixture(displayP3Colorants, srgbCurveFixture)
+	if got := convertColor(t, p3, [3]uint8{128, 128, 128}); !near(got[0], 128) || !near(got[1], 128) || !near(got[2], 128) {
+		t.Errorf("Display P3 profile: expected gray to stay gray, got %v", got)
+	}
+	if got := convertColor(t, p3, [3]uint8{128, 64, 64}); got[0] <= 128 || got[1] >= 64 {
+		t.Errorf("Display P3 profile: expected a more saturated red in sRGB, got %v", got)
+	}
+
+	linear := iccProfileFixture(srgbColorants, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x01\x00"))
+	if got := convertColor(t, linear, [3]uint8{128, 128, 128}); !near(got[0], 188) {
+		t.Errorf("linear profile: expected 128 to encode to 188, got %v", got)
+	}
+
+	table := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x40\x00\xFF\xFF")
+	if _, err := ParseICCProfile(iccProfileFixture(srgbColorants, table)); err != nil {
+		t.Errorf("failed to parse table curve: %v", err)
+	}
+
+	gray := iccProfileFixture(srgbColorants, srgbCurveFixture)
+	copy(gray[16:], "GRAY")
+	if _, err := ParseICCProfile(gray); !errors.Is(err, ErrUnsupportedColorProfile) {
+		t.Errorf("expected ErrUnsupportedColorProfile, got %v", err)
+	}
+}
+
+func TestRenderFrameColorProfile(t *testing.T) {
+	gray := [4]byte{128, 128, 128, 0xFF}
+
+	ase := &AsepriteFile{
+		Header: Header{Width: 1, Height: 1, ColorDepth: ColorDepthRGBA},
+		Frames: []Frame{{Chunks: []Chunk{
+			&ChunkColorProfile{
+				header:                ChunkHeader{Type: ColorProfileChunkHex},
+				ChunkColorProfileData: ChunkColorProfileData{Type: ColorProfileSRGB, Flags: ColorProfileFlagFixedGamma, Gamma: FloatToFixed(1)},
+			},
+			&ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}, ChunkLayerFlags: ChunkLayerFlags{Visible: true}},
+			&ChunkCelImage{
+				header:       ChunkHeader{Type: CelChunkHex},
+				ChunkCelData: ChunkCelData{Opacity: 255},
+				ChunkCelRawImageData: ChunkCelRawImageData{
+					ChunkCelDimensionData: ChunkCelDimensionData{Width: 1, Height: 1},
+					Pixels:                &image.NRGBA{Pix: gray[:], Stride: 4, Rect: image.Rect(0, 0, 1, 1)},
+				},
+			},
+		}}},
+	}
+
+	sprite, err := ase.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	tests := []struct {
+		cm   ColorManagement
+		want color.RGBA
+	}{
+		{ColorManagementSRGB, color.RGBA{188, 188, 188, 0xFF}},
+		{ColorManagementNone, color.RGBA{128, 128, 128, 0xFF}},
+	}
+
+	for _, tt := range tests {
+		img, err := sprite.RenderFrame(0, RenderOptions{ColorManagement: tt.cm})
+		if err != nil {
+			t.Fatalf("failed to render frame: %v", err)
+		}
+
+		if got := img.RGBAAt(0, 0); got != tt.want {
+			t.Errorf("color management %d: got %v, want %v", tt.cm, got, tt.want)
+		}
+	}
+
+	// the converter is built once per profile
+	first, _ := sprite.srgbConverter()
+	if conv, _ := sprite.srgbConverter(); conv == nil || conv != first {
+		t.Errorf("expected the converter to be reused, got %p then %p", first, conv)
+	}
+
+	if len(sprite.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", sprite.Warnings)
+	}
+
+	// profiles that can't be converted render untouched by default
+	unsupported := iccProfileFixture(srgbColorants, srgbCurveFixture)
+	copy(unsupported[16:], "GRAY")
+	for _, data := range [][]byte{unsupported, []byte("not a profile")} {
+		ase.Frames[0].Chunks[0] = &ChunkColorProfileICC{
+			ChunkColorProfile:        ChunkColorProfile{header: ChunkHeader{Type: ColorProfileChunkHex}},
+			ChunkColorProfileICCData: ChunkColorProfileICCData{DataLength: uint32(len(data)), Data: data},
+		}
+
+		sprite, err := ase.Sprite()
+		if err != nil {
+			t.Fatalf("failed to build sprite: %v", err)
+		}
+		if len(sprite.Warnings) != 1 || sprite.Warnings[0].Chunk != ColorProfileChunkHex {
+			t.Errorf("expected a color profile warning, got %v", sprite.Warnings)
+		}
+
+		img, err := sprite.RenderFrame(0, RenderOptions{})
+		if err != nil {
+			t.Fatalf("failed to render frame with an unsupported profile: %v", err)
+		}
+		if got, want := img.RGBAAt(0, 0), (color.RGBA{128, 128, 128, 0xFF}); got != want {
+			t.Errorf("unsupported profile: got %v, want the colors untouched %v", got, want)
+		}
+	}
+}
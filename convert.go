@@ -0,0 +1,566 @@
+package ase
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+)
+
+type Quantizer int
+
+const (
+	// QuantizeMedianCut splits the color space into boxes holding about the
+	// same number of pixels.
+	QuantizeMedianCut Quantizer = iota
+	// QuantizeOctree merges the least used branches of an RGB octree.
+	QuantizeOctree
+)
+
+type Dither int
+
+const (
+	DitherNone Dither = iota
+	// DitherOrdered offsets colors with an 8x8 Bayer matrix.
+	DitherOrdered
+	// DitherFloydSteinberg diffuses the error of each pixel to its
+	// neighbours.
+	DitherFloydSteinberg
+)
+
+// DefaultLuminanceWeights are the Rec. 709 luma weights Aseprite uses.
+var DefaultLuminanceWeights = [3]float64{0.2126, 0.7152, 0.0722}
+
+type ConvertOptions struct {
+	// Palette maps colors to a fixed palette when converting to indexed,
+	// with Header.PaletteEntry as its transparent index. When nil, a
+	// palette of PaletteSize colors is generated with Quantizer, entry 0
+	// being transparent.
+	Palette     Palette
+	PaletteSize int
+	Quantizer   Quantizer
+	Dither      Dither
+	// LuminanceWeights are the red, green and blue weights used to convert
+	// to grayscale. The zero value means DefaultLuminanceWeights.
+	LuminanceWeights [3]float64
+}
+
+// ConvertColorDepth rewrites the pixels of every image cel and tileset in
+// the target color depth and updates the header and palettes to match. The
+// cels and tilesets are changed in place, so the AsepriteFile the sprite was
+// built from sees the new pixels too, and its palette chunks hold the
+// palette of an indexed target.
+func (s *Sprite) ConvertColorDepth(target ColorDepth, opts ConvertOptions) error {
+	if target.BytesPerPixel() == 0 {
+		return fmt.Errorf("convert: %w (%d)", ErrInvalidColorDepth, target)
+	}
+
+	if target == s.ColorDepth && (target != ColorDepthIndexed || opts.Palette == nil) {
+		return nil
+	}
+
+	if opts.Palette != nil && (len(opts.Palette) == 0 || len(opts.Palette) > 256) {
+		return fmt.Errorf("convert: palette of %d colors doesn't fit an indexed image", len(opts.Palette))
+	}
+
+	type celImage struct {
+		img        *image.NRGBA
+		background bool
+		set        func(Pixels)
+	}
+
+	// decode everything first so a failure leaves the sprite untouched
+	var cels []celImage
+	for _, frame := range s.Frames {
+		for _, cel := range frame.Cels {
+			chunk, ok := cel.Chunk.(*ChunkCelImage)
+			if !ok {
+				continue
+			}
+
+			img, err := s.celImage(chunk, s.PaletteAt(cel.Frame), cel.Layer.Background)
+			if err != nil {
+				return fmt.Errorf("convert: frame %d: %w", cel.Frame, err)
+			}
+
+			cels = append(cels, celImage{img, cel.Layer.Background, func(p Pixels) {
+				chunk.Pixels, chunk.lazy = p, nil
+			}})
+		}
+	}
+
+	// tilesets are only found in the first frame, so is their palette
+	for _, tileset := range s.Tilesets {
+		if tileset.TilesetImage == nil {
+			continue
+		}
+
+		width, height := int(tileset.TileWidth), int(tileset.TileHeight)*int(tileset.TilesNumber)
+		img, err := s.pixelsImage(*tileset.TilesetImage, width, height, s.PaletteAt(0), false)
+		if err != nil {
+			return fmt.Errorf("convert: tileset %d: %w", tileset.ID, err)
+		}
+
+		chunk := tileset.ChunkTileset
+		cels = append(cels, celImage{img, false, func(p Pixels) {
+			chunk.TilesetImage = &p
+		}})
+	}
+
+	// cached frames and cels show the old pixels
+	s.cache = nil
+
+	pixels := make([]Pixels, len(cels))
+	var palette Palette
+	transparent := -1
+
+	switch target {
+	case ColorDepthRGBA:
+		for i, cel := range cels {
+			pixels[i] = cel.img
+		}
+	case ColorDepthGrayscale:
+		weights := opts.LuminanceWeights
+		if weights == ([3]float64{}) {
+			weights = DefaultLuminanceWeights
+		}
+
+		for i, cel := range cels {
+			p := NewGrayAlpha(cel.img.Rect)
+			for j := range len(p.Pix) / 2 {
+				c := cel.img.Pix[j*4 : j*4+4]
+				v := weights[0]*float64(c[0]) + weights[1]*float64(c[1]) + weights[2]*float64(c[2])
+				p.Pix[j*2], p.Pix[j*2+1] = uint8(math.Round(min(max(v, 0), 255))), c[3]
+			}
+			pixels[i] = p
+		}
+	case ColorDepthIndexed:
+		palette = opts.Palette
+		if palette != nil {
+			if s.File != nil {
+				transparent = int(s.File.Header.PaletteEntry)
+			}
+		} else {
+			size := opts.PaletteSize
+			if size <= 0 || size > 256 {
+				size = 256
+			}
+			// one entry goes to the transparent color
+			size = max(size, 2)
+
+			images := make([]*image.NRGBA, len(cels))
+			for i, cel := range cels {
+				images[i] = cel.img
+			}
+
+			palette = append(Palette{{}}, quantize(histogram(images), size-1, opts.Quantizer)...)
+			transparent = 0
+		}
+
+		colors := imagePalette(palette, transparent)
+		for i, cel := range cels {
+			skip := transparent
+			if cel.background {
+				skip = -1
+			}
+			pixels[i] = newIndexer(palette, skip).indexImage(cel.img, opts.Dither, colors)
+		}
+	}
+
+	for i, cel := range cels {
+		cel.set(pixels[i])
+	}
+
+	s.ColorDepth = target
+	if s.File != nil {
+		s.File.Header.ColorDepth = target
+	}
+
+	if target == ColorDepthIndexed {
+		s.Palette = palette
+		for _, frame := range s.Frames {
+			frame.Palette = palette
+		}
+
+		if s.File != nil {
+			s.File.Header.NumberColors = uint16(len(palette))
+			if transparent >= 0 {
+				s.File.Header.PaletteEntry = byte(transparent)
+			}
+			s.File.setPalette(palette)
+		}
+	}
+
+	return nil
+}
+
+// setPalette makes p the palette of every frame: each palette chunk is
+// replaced by one holding p, keeping the user data that follows it, and the
+// first frame gets one when it has none.
+func (a *AsepriteFile) setPalette(p Palette) {
+	for i := range a.Frames {
+		chunks := a.Frames[i].Chunks
+		replaced := false
+		for j, chunk := range chunks {
+			switch chunk.(type) {
+			case *ChunkPalette, *ChunkOldPalette, *ChunkOldPalette2:
+				chunks[j] = newPaletteChunk(p)
+				replaced = true
+			}
+		}
+
+		if i == 0 && !replaced {
+			a.Frames[0].Chunks = append([]Chunk{newPaletteChunk(p)}, chunks...)
+			a.Frames[0].Header.ChunkNumber = uint32(len(a.Frames[0].Chunks))
+			a.Frames[0].Header.OldChunkNumber = uint16(min(len(a.Frames[0].Chunks), 0xFFFF))
+		}
+	}
+}
+
+type colorCount struct {
+	c     color.NRGBA
+	count int
+}
+
+// histogram counts the colors of the visible pixels of images, sorted by
+// color so quantizing doesn't depend on map order.
+func histogram(images []*image.NRGBA) []colorCount {
+	counts := map[color.NRGBA]int{}
+	for _, img := range images {
+		for i := 0; i+3 < len(img.Pix); i += 4 {
+			if img.Pix[i+3] == 0 {
+				continue
+			}
+			counts[color.NRGBA{R: img.Pix[i], G: img.Pix[i+1], B: img.Pix[i+2], A: img.Pix[i+3]}]++
+		}
+	}
+
+	colors := make([]colorCount, 0, len(counts))
+	for c, n := range counts {
+		colors = append(colors, colorCount{c, n})
+	}
+
+	slices.SortFunc(colors, func(a, b colorCount) int {
+		return cmp.Compare(packNRGBA(a.c), packNRGBA(b.c))
+	})
+
+	return colors
+}
+
+func packNRGBA(c color.NRGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+func channel(c color.NRGBA, ch int) uint8 {
+	return [4]uint8{c.R, c.G, c.B, c.A}[ch]
+}
+
+func quantize(colors []colorCount, size int, q Quantizer) Palette {
+	if len(colors) <= size {
+		p := make(Palette, len(colors))
+		for i, c := range colors {
+			p[i].NRGBA = c.c
+		}
+		return p
+	}
+
+	if q == QuantizeOctree {
+		return octreeQuantize(colors, size)
+	}
+
+	return medianCut(colors, size)
+}
+
+// averageColor returns the color of a group of counted colors weighted by
+// their count.
+func averageColor(colors []colorCount) color.NRGBA {
+	var sum [4]int
+	total := 0
+	for _, c := range colors {
+		for ch := range 4 {
+			sum[ch] += int(channel(c.c, ch)) * c.count
+		}
+		total += c.count
+	}
+
+	var avg [4]uint8
+	for ch := range 4 {
+		avg[ch] = uint8((sum[ch] + total/2) / total)
+	}
+
+	return color.NRGBA{R: avg[0], G: avg[1], B: avg[2], A: avg[3]}
+}
+
+func medianCut(colors []colorCount, size int) Palette {
+	boxes := [][]colorCount{colors}
+
+	for len(boxes) < size {
+		// split the box with the widest channel range
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+
+			for ch := range 4 {
+				lo, hi := uint8(255), uint8(0)
+				for _, c := range box {
+					lo, hi = min(lo, channel(c.c, ch)), max(hi, channel(c.c, ch))
+				}
+				if int(hi)-int(lo) > bestRange {
+					best, bestChannel, bestRange = i, ch, int(hi)-int(lo)
+				}
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		slices.SortStableFunc(box, func(a, b colorCount) int {
+			return cmp.Compare(channel(a.c, bestChannel), channel(b.c, bestChannel))
+		})
+
+		total := 0
+		for _, c := range box {
+			total += c.count
+		}
+
+		// cut at the weighted median, keeping both halves non empty
+		cut, seen := 1, box[0].count
+		for cut < len(box)-1 && seen < total/2 {
+			seen += box[cut].count
+			cut++
+		}
+
+		boxes[best] = box[:cut]
+		boxes = append(boxes, box[cut:])
+	}
+
+	p := make(Palette, len(boxes))
+	for i, box := range boxes {
+		p[i].NRGBA = averageColor(box)
+	}
+
+	return p
+}
+
+type octreeNode struct {
+	children [8]*octreeNode
+	sum      [4]int
+	count    int
+	leaf     bool
+}
+
+func octreeQuantize(colors []colorCount, size int) Palette {
+	root := &octreeNode{}
+	var levels [8][]*octreeNode
+	leaves := 0
+
+	for _, c := range colors {
+		node := root
+		for level := range 8 {
+			shift := 7 - level
+			i := int(c.c.R>>shift&1)<<2 | int(c.c.G>>shift&1)<<1 | int(c.c.B>>shift&1)
+			if node.children[i] == nil {
+				node.children[i] = &octreeNode{leaf: level == 7}
+				if level == 7 {
+					leaves++
+				} else {
+					levels[level+1] = append(levels[level+1], node.children[i])
+				}
+			}
+			node = node.children[i]
+		}
+
+		for ch := range 4 {
+			node.sum[ch] += int(channel(c.c, ch)) * c.count
+		}
+		node.count += c.count
+	}
+	levels[0] = []*octreeNode{root}
+
+	// fold the deepest branches into their parents until the leaves fit
+	for level := 7; level >= 0 && leaves > size; level-- {
+		nodes := levels[level]
+		// fold the least used branches first
+		slices.SortStableFunc(nodes, func(a, b *octreeNode) int {
+			return cmp.Compare(a.subtreeCount(), b.subtreeCount())
+		})
+
+		for _, node := range nodes {
+			if leaves <= size {
+				break
+			}
+
+			children := 0
+			for i, child := range node.children {
+				if child == nil {
+					continue
+				}
+				for ch := range 4 {
+					node.sum[ch] += child.sum[ch]
+				}
+				node.count += child.count
+				node.children[i] = nil
+				children++
+			}
+			node.leaf = true
+			leaves -= children - 1
+		}
+	}
+
+	var p Palette
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		if node.leaf {
+			var avg [4]uint8
+			for ch := range 4 {
+				avg[ch] = uint8((node.sum[ch] + node.count/2) / node.count)
+			}
+			p = append(p, PaletteEntry{NRGBA: color.NRGBA{R: avg[0], G: avg[1], B: avg[2], A: avg[3]}})
+			return
+		}
+
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	collect(root)
+
+	return p
+}
+
+func (n *octreeNode) subtreeCount() int {
+	total := n.count
+	for _, child := range n.children {
+		if child != nil {
+			total += child.subtreeCount()
+		}
+	}
+
+	return total
+}
+
+// indexer finds the closest palette entry of colors, never picking skip
+// (the transparent index) for visible colors.
+type indexer struct {
+	palette Palette
+	skip    int
+	cache   map[color.NRGBA]uint8
+}
+
+func newIndexer(palette Palette, skip int) *indexer {
+	return &indexer{palette: palette, skip: skip, cache: map[color.NRGBA]uint8{}}
+}
+
+func (x *indexer) index(c color.NRGBA) uint8 {
+	if c.A == 0 && x.skip >= 0 {
+		return uint8(x.skip)
+	}
+
+	if i, ok := x.cache[c]; ok {
+		return i
+	}
+
+	best, bestDist := 0, math.MaxInt
+	for i, e := range x.palette {
+		if i == x.skip {
+			continue
+		}
+
+		dr, dg := int(c.R)-int(e.R), int(c.G)-int(e.G)
+		db, da := int(c.B)-int(e.B), int(c.A)-int(e.A)
+		if dist := dr*dr + dg*dg + db*db + da*da; dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+
+	x.cache[c] = uint8(best)
+	return uint8(best)
+}
+
+// bayer8 is the 8x8 ordered dithering threshold matrix.
+var bayer8 = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// indexImage returns img mapped to the palette of x, as an image using
+// colors for its palette.
+func (x *indexer) indexImage(img *image.NRGBA, dither Dither, colors color.Palette) *image.Paletted {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	out := image.NewPaletted(image.Rect(0, 0, width, height), colors)
+
+	// spread of the ordered dither, about the distance between palette
+	// colors if they were evenly spread over the RGB cube
+	spread := 255 / math.Cbrt(float64(max(len(x.palette), 1)))
+
+	// error diffusion rows, one float per channel with a pixel of margin
+	// on each side
+	var current, next []float64
+	if dither == DitherFloydSteinberg {
+		current = make([]float64, (width+2)*3)
+		next = make([]float64, (width+2)*3)
+	}
+
+	for y := range height {
+		for px := range width {
+			i := y*width + px
+			c := color.NRGBA{R: img.Pix[i*4], G: img.Pix[i*4+1], B: img.Pix[i*4+2], A: img.Pix[i*4+3]}
+			if c.A == 0 {
+				out.Pix[i] = x.index(c)
+				continue
+			}
+
+			want := [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+			switch dither {
+			case DitherOrdered:
+				offset := (float64(bayer8[y%8][px%8])+0.5)/64 - 0.5
+				for ch := range want {
+					want[ch] += offset * spread
+				}
+			case DitherFloydSteinberg:
+				for ch := range want {
+					want[ch] += current[(px+1)*3+ch]
+				}
+			}
+
+			target := color.NRGBA{R: clampByte(want[0]), G: clampByte(want[1]), B: clampByte(want[2]), A: c.A}
+			out.Pix[i] = x.index(target)
+
+			if dither == DitherFloydSteinberg {
+				got := x.palette[out.Pix[i]]
+				for ch, v := range [3]uint8{got.R, got.G, got.B} {
+					e := want[ch] - float64(v)
+					current[(px+2)*3+ch] += e * 7 / 16
+					next[px*3+ch] += e * 3 / 16
+					next[(px+1)*3+ch] += e * 5 / 16
+					next[(px+2)*3+ch] += e * 1 / 16
+				}
+			}
+		}
+
+		if dither == DitherFloydSteinberg {
+			current, next = next, current
+			clear(next)
+		}
+	}
+
+	return out
+}
+
+func clampByte(v float64) uint8 {
+	return uint8(math.Round(min(max(v, 0), 255)))
+}
@@ -0,0 +1,237 @@
+package ase
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func loadTestSprite(t *testing.T) *Sprite {
+	t.Helper()
+
+	fd, err := os.Open(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to open file %s: %v", testFilePath, err)
+	}
+	defer fd.Close()
+
+	ase, err := DeserializeFile(fd)
+	if err != nil {
+		t.Fatalf("failed to deserialize file %s: %v", testFilePath, err)
+	}
+
+	sprite, err := ase.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	return sprite
+}
+
+func renderAll(t *testing.T, sprite *Sprite) []*image.RGBA {
+	t.Helper()
+
+	images := make([]*image.RGBA, len(sprite.Frames))
+	for i := range sprite.Frames {
+		img, err := sprite.RenderFrame(i, RenderOptions{})
+		if err != nil {
+			t.Fatalf("failed to render frame %d: %v", i, err)
+		}
+		images[i] = img
+	}
+
+	return images
+}
+
+func TestConvertColorDepth(t *testing.T) {
+	for _, q := range []Quantizer{QuantizeMedianCut, QuantizeOctree} {
+		sprite := loadTestSprite(t)
+		want := renderAll(t, sprite)
+
+		// the sprite has fewer colors than a palette holds, so the round
+		// trip through indexed is lossless
+		if err := sprite.ConvertColorDepth(ColorDepthIndexed, ConvertOptions{Quantizer: q}); err != nil {
+			t.Fatalf("failed to convert to indexed: %v", err)
+		}
+
+		if sprite.File.Header.ColorDepth != ColorDepthIndexed || sprite.Palette[sprite.File.Header.PaletteEntry].A != 0 {
+			t.Fatalf("unexpected header after conversion: %+v", sprite.File.Header)
+		}
+
+		if _, ok := sprite.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*image.Paletted); !ok {
+			t.Fatalf("expected indexed pixels")
+		}
+
+		// the file holds the new palette too
+		rebuilt, err := sprite.File.Sprite()
+		if err != nil {
+			t.Fatalf("failed to rebuild the sprite: %v", err)
+		}
+		if !reflect.DeepEqual(rebuilt.Palette, sprite.Palette) {
+			t.Fatalf("quantizer %d: the file palette %v differs from the sprite palette %v", q, rebuilt.Palette, sprite.Palette)
+		}
+		for i, img := range renderAll(t, rebuilt) {
+			if !bytes.Equal(img.Pix, want[i].Pix) {
+				t.Fatalf("quantizer %d: frame %d of the file differs after the conversion", q, i)
+			}
+		}
+
+		if err := sprite.ConvertColorDepth(ColorDepthRGBA, ConvertOptions{}); err != nil {
+			t.Fatalf("failed to convert back to RGBA: %v", err)
+		}
+
+		for i, img := range renderAll(t, sprite) {
+			for j := range img.Pix {
+				if img.Pix[j] != want[i].Pix[j] {
+					t.Fatalf("quantizer %d: frame %d differs after the round trip at byte %d", q, i, j)
+				}
+			}
+		}
+	}
+
+	sprite := loadTestSprite(t)
+	if err := sprite.ConvertColorDepth(ColorDepthGrayscale, ConvertOptions{LuminanceWeights: [3]float64{1, 0, 0}}); err != nil {
+		t.Fatalf("failed to convert to grayscale: %v", err)
+	}
+
+	want := loadTestSprite(t).Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*image.NRGBA)
+	got := sprite.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*GrayAlpha)
+	for y := range want.Rect.Dy() {
+		for x := range want.Rect.Dx() {
+			w, g := want.NRGBAAt(x, y), got.GrayAlphaAt(x, y)
+			if g.Y != w.R || g.A != w.A {
+				t.Fatalf("pixel (%d, %d): got %v, want the red channel and alpha of %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestConvertColorDepthQuantize(t *testing.T) {
+	// a 64x64 image with 4096 colors
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8((x + y) * 2), A: 0xFF})
+		}
+	}
+
+	for _, q := range []Quantizer{QuantizeMedianCut, QuantizeOctree} {
+		p := quantize(histogram([]*image.NRGBA{img}), 31, q)
+		if len(p) == 0 || len(p) > 31 {
+			t.Errorf("quantizer %d: unexpected palette size %d", q, len(p))
+		}
+	}
+}
+
+func TestConvertColorDepthDither(t *testing.T) {
+	pixels := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(pixels, pixels.Rect, image.NewUniform(color.NRGBA{R: 128, G: 128, B: 128, A: 0xFF}), image.Point{}, draw.Src)
+	total := 16 * 16
+
+	palette := Palette{
+		{},
+		{NRGBA: color.NRGBA{A: 0xFF}},
+		{NRGBA: color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}},
+	}
+
+	for _, dither := range []Dither{DitherNone, DitherOrdered, DitherFloydSteinberg} {
+		cel := &ChunkCelImage{
+			header:       ChunkHeader{Type: CelChunkHex},
+			ChunkCelData: ChunkCelData{Opacity: 255},
+			ChunkCelRawImageData: ChunkCelRawImageData{
+				ChunkCelDimensionData: ChunkCelDimensionData{Width: 16, Height: 16},
+				Pixels:                &image.NRGBA{Pix: bytes.Clone(pixels.Pix), Stride: pixels.Stride, Rect: pixels.Rect},
+			},
+		}
+		ase := &AsepriteFile{
+			Header: Header{Width: 16, Height: 16, ColorDepth: ColorDepthRGBA},
+			Frames: []Frame{{Chunks: []Chunk{&ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}}, cel}}},
+		}
+
+		sprite, err := ase.Sprite()
+		if err != nil {
+			t.Fatalf("failed to build sprite: %v", err)
+		}
+
+		if err := sprite.ConvertColorDepth(ColorDepthIndexed, ConvertOptions{Palette: palette, Dither: dither}); err != nil {
+			t.Fatalf("failed to convert: %v", err)
+		}
+
+		white := 0
+		for _, i := range cel.Pixels.(*image.Paletted).Pix {
+			if i == 0 {
+				t.Fatalf("dither %d: visible pixel mapped to the transparent index", dither)
+			}
+			if i == 2 {
+				white++
+			}
+		}
+
+		// mid gray is a single color without dithering and about half white
+		// with it
+		if dither == DitherNone && white != 0 && white != total {
+			t.Errorf("dither %d: expected a single color, got %d white pixels", dither, white)
+		}
+		if dither != DitherNone && (white < total*2/5 || white > total*3/5) {
+			t.Errorf("dither %d: expected about half white pixels, got %d of %d", dither, white, total)
+		}
+	}
+}
+
+func TestConvertColorDepthInvalid(t *testing.T) {
+	sprite := loadTestSprite(t)
+	for _, dither := range []Dither{DitherNone, DitherOrdered, DitherFloydSteinberg} {
+		if err := sprite.ConvertColorDepth(ColorDepthIndexed, ConvertOptions{Palette: Palette{}, Dither: dither}); err == nil {
+			t.Errorf("dither %d: expected an error for an empty palette", dither)
+		}
+	}
+
+	// a failed conversion keeps the sprite and its cache
+	sprite.SetRenderCache(1)
+	if _, err := sprite.RenderFrame(0, RenderOptions{}); err != nil {
+		t.Fatalf("failed to render frame 0: %v", err)
+	}
+	sprite.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels = image.NewNRGBA(image.Rect(0, 0, 1, 1))
+
+	if err := sprite.ConvertColorDepth(ColorDepthGrayscale, ConvertOptions{}); !errors.Is(err, ErrInvalidPixels) {
+		t.Fatalf("expected ErrInvalidPixels, got %v", err)
+	}
+	if sprite.ColorDepth != ColorDepthRGBA || sprite.cache == nil {
+		t.Errorf("expected the sprite to be left as it was, got color depth %d and cache %v", sprite.ColorDepth, sprite.cache)
+	}
+}
+
+func TestConvertColorDepthTileset(t *testing.T) {
+	loader := &Loader{Buffer: bytes.NewBuffer(tilesetChunkFixture)}
+	chunk, err := loader.ParseChunkTileset(ChunkHeader{Size: uint32(len(tilesetChunkFixture)) + ChunkHeaderSize, Type: TilesetChunkHex})
+	if err != nil {
+		t.Fatalf("failed to parse the tileset: %v", err)
+	}
+
+	sprite := loadTestSprite(t)
+	tileset := chunk.(*ChunkTileset)
+	sprite.Tilesets = append(sprite.Tilesets, &Tileset{ChunkTileset: tileset})
+	want := (*tileset.TilesetImage).(*image.NRGBA)
+
+	if err := sprite.ConvertColorDepth(ColorDepthGrayscale, ConvertOptions{LuminanceWeights: [3]float64{0, 1, 0}}); err != nil {
+		t.Fatalf("failed to convert to grayscale: %v", err)
+	}
+
+	got, ok := (*tileset.TilesetImage).(*GrayAlpha)
+	if !ok || got.Rect != want.Rect {
+		t.Fatalf("expected a grayscale tileset image of %v, got %T", want.Rect, *tileset.TilesetImage)
+	}
+	for y := range want.Rect.Dy() {
+		for x := range want.Rect.Dx() {
+			w, g := want.NRGBAAt(x, y), got.GrayAlphaAt(x, y)
+			if g.Y != w.G || g.A != w.A {
+				t.Fatalf("tile pixel (%d, %d): got %v, want the green channel and alpha of %v", x, y, g, w)
+			}
+		}
+	}
+}
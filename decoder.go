@@ -0,0 +1,167 @@
+package ase
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"io"
+	"slices"
+)
+
+const (
+	// decoderReaderSize is the size of the bufio.Reader of a Decoder.
+	decoderReaderSize = 32 << 10
+	// maxPooledBuffer is the largest buffer a Decoder keeps for the next
+	// file, so one huge chunk does not pin its memory in a pool.
+	maxPooledBuffer = 4 << 20
+)
+
+// Decoder decodes files like Decode, but keeps its buffers and zlib readers
+// from one file to the next, which saves most allocations that are not the
+// decoded file itself when many files are decoded.
+//
+// A Decoder decodes one file at a time. It holds nothing of a file once
+// Decode returns, so decoders can be shared between goroutines through a
+// sync.Pool. The zero value decodes with the default options.
+type Decoder struct {
+	Options DecodeOptions
+
+	loader   Loader
+	reader   *bufio.Reader
+	buffer   bytes.Buffer
+	buf      []byte
+	inflater inflater
+	cels     celQueue
+}
+
+func NewDecoder(opts DecodeOptions) *Decoder {
+	return &Decoder{Options: opts}
+}
+
+func (d *Decoder) Decode(r io.Reader) (*AsepriteFile, error) {
+	return d.DecodeContext(context.Background(), r)
+}
+
+// DecodeContext is Decode, stopping with the context error once ctx is done.
+// r is read through a bufio.Reader, so it may be read past the end of the
+// file, except for an io.Seeker decoded with PixelModeSkip which is seeked
+// past the pixel data instead.
+func (d *Decoder) DecodeContext(ctx context.Context, r io.Reader) (*AsepriteFile, error) {
+	defer d.release()
+
+	reader := r
+	if _, ok := r.(io.Seeker); !ok || d.Options.Pixels != PixelModeSkip {
+		if d.reader == nil {
+			d.reader = bufio.NewReaderSize(r, decoderReaderSize)
+		} else {
+			d.reader.Reset(r)
+		}
+		reader = d.reader
+	}
+
+	if d.buf == nil {
+		d.buf = make([]byte, ChunkSize)
+	}
+
+	d.loader = Loader{
+		Reader:   reader,
+		Buf:      d.buf,
+		Buffer:   &d.buffer,
+		File:     new(AsepriteFile),
+		Options:  d.Options,
+		ctx:      ctx,
+		inflater: &d.inflater,
+	}
+	if d.Options.Pixels == PixelModeEager && d.Options.workers() > 1 {
+		d.loader.cels = &d.cels
+	}
+
+	return d.loader.decode()
+}
+
+// release drops every reference to the last file, keeping the memory that
+// is reused.
+func (d *Decoder) release() {
+	d.loader = Loader{}
+
+	if d.reader != nil {
+		d.reader.Reset(nil)
+	}
+
+	if d.buffer.Cap() > maxPooledBuffer {
+		d.buffer = bytes.Buffer{}
+	}
+	d.buffer.Reset()
+
+	d.inflater.src.Reset(nil)
+	for i := range d.cels.inflaters {
+		d.cels.inflaters[i].src.Reset(nil)
+	}
+	clear(d.cels.jobs)
+	d.cels.jobs = d.cels.jobs[:0]
+}
+
+// inflater inflates zlib data, reusing its zlib reader through
+// zlib.Resetter.
+type inflater struct {
+	src   bytes.Reader
+	zr    io.ReadCloser
+	probe [1]byte
+}
+
+// inflate inflates p, reserving the output from b as it grows and failing
+// with a *LimitError once b runs out. size is the expected size of the
+// output, which is then allocated once.
+func (f *inflater) inflate(p []byte, b *budget, size int) ([]byte, error) {
+	f.src.Reset(p)
+	defer f.src.Reset(nil)
+
+	if f.zr == nil {
+		zr, err := zlib.NewReader(&f.src)
+		if err != nil {
+			return nil, err
+		}
+		f.zr = zr
+	} else if err := f.zr.(zlib.Resetter).Reset(&f.src, nil); err != nil {
+		return nil, err
+	}
+
+	// deflate does not inflate past 1032:1, a size beyond that is not
+	// trusted
+	reserved := b.grant(int64(min(max(size, 0), len(p)*1032)))
+	out := make([]byte, 0, reserved)
+	for {
+		// a full output is only grown once a read into probe finds more
+		// data, so output of the expected size is allocated once
+		buf := out[len(out):reserved]
+		if len(buf) == 0 {
+			buf = f.probe[:]
+		}
+
+		n, err := f.zr.Read(buf)
+		if int64(len(out)) < reserved {
+			out = out[:len(out)+n]
+		} else if n > 0 {
+			// grow like append does, as far as the budget goes
+			grown := b.grant(max(reserved, 512))
+			if grown == 0 {
+				b.release(reserved)
+				return nil, b.exceeded(1)
+			}
+			reserved += grown
+			out = append(slices.Grow(out, int(reserved)-len(out)), f.probe[0])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			b.release(reserved)
+			return nil, err
+		}
+	}
+
+	b.release(reserved - int64(len(out)))
+
+	return out, nil
+}
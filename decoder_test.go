@@ -0,0 +1,104 @@
+package ase
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	file, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+	files := [][]byte{file, animationFixture(8, 32), file}
+
+	for _, opts := range []DecodeOptions{{}, {Workers: 2}, {Pixels: PixelModeSkip}} {
+		d := NewDecoder(opts)
+		for i, data := range files {
+			want, err := Decode(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Fatalf("file %d: failed to decode: %v", i, err)
+			}
+
+			got, err := d.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("file %d: decoder failed: %v", i, err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("file %d with %+v: decoder and Decode differ", i, opts)
+			}
+
+			// a failed file leaves nothing behind for the next one
+			if _, err := d.Decode(bytes.NewReader(data[:len(data)/2])); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("file %d: expected io.ErrUnexpectedEOF for a truncated file, got %v", i, err)
+			}
+		}
+	}
+
+	pool := sync.Pool{New: func() any { return new(Decoder) }}
+	want, err := DeserializeFile(bytes.NewReader(files[1]))
+	if err != nil {
+		t.Fatalf("failed to decode the animation: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 4 {
+				d := pool.Get().(*Decoder)
+				got, err := d.Decode(bytes.NewReader(files[1]))
+				pool.Put(d)
+
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("pooled decoder differs from Decode, error %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkDecoderAnimation(b *testing.B) {
+	d := NewDecoder(DecodeOptions{})
+	benchmarkAnimation(b, func(r io.ReadSeeker) error {
+		_, err := d.Decode(r)
+		return err
+	})
+}
+
+func BenchmarkDecoderFile(b *testing.B) {
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		b.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+
+	b.Run("Decode", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := DeserializeFile(bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Decoder", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		d := NewDecoder(DecodeOptions{})
+		for b.Loop() {
+			if _, err := d.Decode(bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package ase
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"iter"
+)
+
+// FrameReader reads a file one frame at a time, so memory stays bound to
+// one frame instead of the whole file. It keeps only what later frames
+// depend on: the layers read so far and the palette in effect.
+//
+// Earlier frames are not kept, a linked cel is left for the caller to
+// resolve through its FramePosition.
+type FrameReader struct {
+	Header Header
+
+	loader   Loader
+	next     int
+	err      error
+	layers   layerTree
+	attacher userDataAttacher
+}
+
+func NewFrameReader(r io.Reader, opts DecodeOptions) (*FrameReader, error) {
+	return NewFrameReaderContext(context.Background(), r, opts)
+}
+
+// NewFrameReaderContext reads the header of the file, ctx being checked
+// while frames are read.
+func NewFrameReaderContext(ctx context.Context, r io.Reader, opts DecodeOptions) (*FrameReader, error) {
+	fr := &FrameReader{
+		loader: Loader{
+			Reader:  r,
+			Buf:     make([]byte, ChunkSize),
+			Buffer:  new(bytes.Buffer),
+			File:    new(AsepriteFile),
+			Options: opts,
+			ctx:     ctx,
+		},
+	}
+	if opts.Pixels == PixelModeEager && opts.workers() > 1 {
+		fr.loader.cels = new(celQueue)
+	}
+
+	header, err := fr.loader.ParseHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := opts.checkHeader(header); err != nil {
+		return nil, err
+	}
+	fr.Header, fr.loader.File.Header = header, header
+
+	return fr, nil
+}
+
+// NextFrame reads the next frame. It returns io.EOF once every frame was
+// read and the end of the file checked, and keeps returning the first error
+// it met.
+func (fr *FrameReader) NextFrame() (Frame, error) {
+	if fr.err != nil {
+		return Frame{}, fr.err
+	}
+
+	if fr.next == int(fr.Header.Frames) {
+		fr.err = fr.loader.checkFileEnd(&fr.Header)
+		if fr.err == nil {
+			fr.err = io.EOF
+		}
+		return Frame{}, fr.err
+	}
+
+	frame, err := fr.readFrame()
+	if err != nil {
+		fr.err = err
+		return Frame{}, err
+	}
+
+	fr.track(frame)
+	fr.next++
+
+	return frame, nil
+}
+
+// readFrame parses the next frame and decodes its queued cels.
+func (fr *FrameReader) readFrame() (Frame, error) {
+	l := &fr.loader
+	frame, err := l.parseFrame(fr.next)
+	if l.cels != nil {
+		// a queued cel may hold an error serial decoding would have hit
+		// first
+		if cerr := l.decodeCels(); cerr != nil && (err == nil || l.done() == nil) {
+			err = cerr
+		}
+	}
+
+	return frame, err
+}
+
+// track carries the layers of frame forward, with their user data.
+func (fr *FrameReader) track(frame Frame) {
+	for _, chunk := range frame.Chunks {
+		switch c := chunk.(type) {
+		case *ChunkLayer:
+			layer := fr.layers.add(c)
+			fr.attacher.reset(func(u *ChunkUserData) { layer.UserData = u })
+		case *ChunkUserData:
+			fr.attacher.attach(c)
+		case *ChunkCelExtra:
+			// user data may still follow for the cel before it
+		default:
+			fr.attacher.reset()
+		}
+	}
+}
+
+// Frames yields every frame with its index, stopping at the first error,
+// which Err reports.
+func (fr *FrameReader) Frames() iter.Seq2[int, Frame] {
+	return func(yield func(int, Frame) bool) {
+		for {
+			index := fr.next
+			frame, err := fr.NextFrame()
+			if err != nil || !yield(index, frame) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the first error met reading frames, nil once every frame was
+// read.
+func (fr *FrameReader) Err() error {
+	if fr.err == io.EOF {
+		return nil
+	}
+
+	return fr.err
+}
+
+// Layers returns the layers of the frames read so far.
+func (fr *FrameReader) Layers() []*Layer {
+	return fr.layers.layers
+}
+
+// Palette returns the palette in effect for the last frame read.
+func (fr *FrameReader) Palette() Palette {
+	return fr.loader.palette
+}
+
+// Warnings returns the warnings of the frames read so far.
+func (fr *FrameReader) Warnings() []Warning {
+	return fr.loader.File.Warnings
+}
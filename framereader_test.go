@@ -0,0 +1,109 @@
+package ase
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFrameReader(t *testing.T) {
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+
+	sprite := loadTestSprite(t)
+	fr, err := NewFrameReader(bytes.NewReader(data), DecodeOptions{})
+	if err != nil {
+		t.Fatalf("failed to read the header: %v", err)
+	}
+
+	if fr.Header != sprite.File.Header {
+		t.Errorf("unexpected header: %+v", fr.Header)
+	}
+
+	read := 0
+	for i, frame := range fr.Frames() {
+		if i != read || !reflect.DeepEqual(frame, sprite.File.Frames[i]) {
+			t.Errorf("frame %d differs from the decoded frame", i)
+		}
+
+		if !reflect.DeepEqual(fr.Palette(), sprite.PaletteAt(i)) {
+			t.Errorf("frame %d: unexpected palette", i)
+		}
+		read++
+	}
+
+	if err := fr.Err(); err != nil || read != len(sprite.Frames) {
+		t.Fatalf("read %d frames, error %v", read, err)
+	}
+
+	if !reflect.DeepEqual(fr.Layers(), sprite.Layers) {
+		t.Errorf("unexpected layers: %d, want %d", len(fr.Layers()), len(sprite.Layers))
+	}
+
+	if _, err := fr.NextFrame(); err != io.EOF {
+		t.Errorf("expected io.EOF after the last frame, got %v", err)
+	}
+}
+
+func TestFrameReaderWorkers(t *testing.T) {
+	data := animationFixture(16, 32)
+	want, err := DeserializeFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode the animation: %v", err)
+	}
+
+	fr, err := NewFrameReader(bytes.NewReader(data), DecodeOptions{Workers: 2})
+	if err != nil {
+		t.Fatalf("failed to read the header: %v", err)
+	}
+
+	// stopping the loop leaves the next frames to NextFrame
+	for i := range fr.Frames() {
+		if i == 3 {
+			break
+		}
+	}
+
+	for i := 4; ; i++ {
+		frame, err := fr.NextFrame()
+		if err == io.EOF {
+			if i != len(want.Frames) {
+				t.Errorf("expected %d frames, got %d", len(want.Frames), i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+
+		if !reflect.DeepEqual(frame, want.Frames[i]) {
+			t.Errorf("frame %d differs from the decoded frame", i)
+		}
+	}
+}
+
+func TestFrameReaderTruncated(t *testing.T) {
+	data := animationFixture(4, 16)
+	fr, err := NewFrameReader(bytes.NewReader(data[:len(data)-10]), DecodeOptions{})
+	if err != nil {
+		t.Fatalf("failed to read the header: %v", err)
+	}
+
+	read := 0
+	for range fr.Frames() {
+		read++
+	}
+
+	if !errors.Is(fr.Err(), io.ErrUnexpectedEOF) || read != 3 {
+		t.Errorf("expected 3 frames and io.ErrUnexpectedEOF, got %d frames and %v", read, fr.Err())
+	}
+
+	if _, err := fr.NextFrame(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected the error to stick, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package ase
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"testing"
+)
+
+// userDataChunkFixture holds text, color and a properties map with a vector,
+// so the fuzzer starts from the user data readers.
+var userDataChunkFixture = []byte{
+	0x07, 0x00, 0x00, 0x00, // Flags = text | color | properties
+	0x02, 0x00, 'h', 'i', // Text = "hi"
+	0xFF, 0x00, 0x00, 0xFF, // Color = red
+
+	0x27, 0x00, 0x00, 0x00, // Size of all maps = 39
+	0x01, 0x00, 0x00, 0x00, // Number of maps = 1
+	0x00, 0x00, 0x00, 0x00, // Map key = 0
+	0x02, 0x00, 0x00, 0x00, // Number of properties = 2
+
+	0x02, 0x00, 'h', 'p', // Name = "hp"
+	0x06, 0x00, // Type = int32
+	0x64, 0x00, 0x00, 0x00, // Value = 100
+
+	0x01, 0x00, 'v', // Name = "v"
+	0x11, 0x00, // Type = vector
+	0x02, 0x00, 0x00, 0x00, // Number of elements = 2
+	0x03, 0x00, // Element type = uint8
+	0x01, 0x02,
+}
+
+var colorProfileChunkFixture = []byte{
+	0x01, 0x00, // Type = sRGB
+	0x01, 0x00, // Flags = fixed gamma
+	0x00, 0x00, 0x01, 0x00, // Gamma = 1.0
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// chunkFixtures holds a body of every chunk type that has one.
+var chunkFixtures = []struct {
+	name string
+	chunkFixture
+}{
+	{"Cel", chunkFixture{CelChunkHex, celChunkFixture}},
+	{"Tileset", chunkFixture{TilesetChunkHex, tilesetChunkFixture}},
+	{"Tags", chunkFixture{TagsChunkHex, tagChunkFixture}},
+	{"Slice", chunkFixture{SliceChunkHex, sliceChunkFixture}},
+	{"ExternalFiles", chunkFixture{ExternalFilesChunkHex, externalFilesChunkFixture}},
+	{"CelExtra", chunkFixture{CelExtraChunkHex, celExtraChunkFixture}},
+	{"Palette", chunkFixture{PaletteChunkHex, paletteChunkFixture}},
+	{"Layer", chunkFixture{LayerChunkHex, layerChunkFixture}},
+	{"OldPalette", chunkFixture{OldPaletteChunkHex, oldPaletteChunkFixture}},
+	{"OldPalette2", chunkFixture{OldPaletteChunk2Hex, oldPaletteChunkFixture}},
+	{"UserData", chunkFixture{UserDataChunkHex, userDataChunkFixture}},
+	{"ColorProfile", chunkFixture{ColorProfileChunkHex, colorProfileChunkFixture}},
+}
+
+func fuzzLoader(data []byte, depth ColorDepth) *Loader {
+	return &Loader{
+		Buffer: bytes.NewBuffer(data),
+		File:   &AsepriteFile{Header: Header{Width: 36, Height: 36, ColorDepth: depth}},
+	}
+}
+
+// fuzzChunk runs parse over arbitrary chunk bodies; it only checks the parser
+// returns instead of panicking.
+func fuzzChunk(f *testing.F, typ ChunkDataType, parse func(*Loader, ChunkHeader) (Chunk, error), seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed, uint8(0))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, depth uint8) {
+		depths := []ColorDepth{ColorDepthRGBA, ColorDepthGrayscale, ColorDepthIndexed}
+		ch := ChunkHeader{Size: uint32(len(data)) + ChunkHeaderSize, Type: typ}
+		parse(fuzzLoader(data, depths[int(depth)%len(depths)]), ch)
+	})
+}
+
+func FuzzDeserializeFile(f *testing.F) {
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		f.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+
+	// testdata/fuzz/FuzzDeserializeFile holds small whole files, among them
+	// the first frame of test.aseprite. The fuzzer minimizes every input
+	// that finds new code in time quadratic in its size, so seeds are kept
+	// to a few hundred bytes rather than starting from the whole file.
+	f.Add(data[:HeaderSize])
+	f.Add(make([]byte, 512))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DeserializeFile(bytes.NewReader(data))
+
+		for _, mode := range []PixelMode{PixelModeLazy, PixelModeSkip} {
+			ase, err := Decode(bytes.NewReader(data), DecodeOptions{Pixels: mode})
+			if err != nil {
+				continue
+			}
+			for _, frame := range ase.Frames {
+				for _, c := range frame.Chunks {
+					if cel, ok := c.(*ChunkCelImage); ok {
+						cel.LoadPixels()
+					}
+				}
+			}
+		}
+	})
+}
+
+func FuzzParseChunk(f *testing.F) {
+	for _, c := range chunkFixtures {
+		f.Add(uint16(c.typ), c.body)
+	}
+
+	f.Fuzz(func(t *testing.T, typ uint16, data []byte) {
+		ch := ChunkHeader{Size: uint32(len(data)) + ChunkHeaderSize, Type: ChunkDataType(typ)}
+		fuzzLoader(data, ColorDepthRGBA).ParseChunk(ch, 0)
+	})
+}
+
+func FuzzParseChunkCel(f *testing.F) {
+	fuzzChunk(f, CelChunkHex, func(l *Loader, ch ChunkHeader) (Chunk, error) {
+		return l.ParseChunkCel(ch, 0)
+	}, celChunkFixture)
+}
+
+func FuzzParseChunkTileset(f *testing.F) {
+	fuzzChunk(f, TilesetChunkHex, (*Loader).ParseChunkTileset, tilesetChunkFixture)
+}
+
+func FuzzParseChunkTag(f *testing.F) {
+	fuzzChunk(f, TagsChunkHex, (*Loader).ParseChunkTag, tagChunkFixture)
+}
+
+func FuzzParseChunkSlice(f *testing.F) {
+	fuzzChunk(f, SliceChunkHex, (*Loader).ParseChunkSlice, sliceChunkFixture)
+}
+
+func FuzzParseChunkExternalFiles(f *testing.F) {
+	fuzzChunk(f, ExternalFilesChunkHex, (*Loader).ParseChunkExternalFiles, externalFilesChunkFixture)
+}
+
+func FuzzParseChunkCelExtra(f *testing.F) {
+	fuzzChunk(f, CelExtraChunkHex, (*Loader).ParseChunkCelExtra, celExtraChunkFixture)
+}
+
+func FuzzParseChunkPalette(f *testing.F) {
+	fuzzChunk(f, PaletteChunkHex, (*Loader).ParseChunkPalette, paletteChunkFixture)
+}
+
+func FuzzParseChunkLayer(f *testing.F) {
+	fuzzChunk(f, LayerChunkHex, (*Loader).ParseChunkLayer, layerChunkFixture)
+}
+
+func FuzzParseChunkOldPalette(f *testing.F) {
+	fuzzChunk(f, OldPaletteChunkHex, (*Loader).ParseChunkOldPalette, oldPaletteChunkFixture)
+}
+
+func FuzzParseChunkUserData(f *testing.F) {
+	fuzzChunk(f, UserDataChunkHex, (*Loader).ParseChunkUserData, userDataChunkFixture)
+}
+
+func FuzzParseChunkColorProfile(f *testing.F) {
+	fuzzChunk(f, ColorProfileChunkHex, (*Loader).ParseChunkColorProfile, colorProfileChunkFixture)
+}
+
+func FuzzPixelsZlibDecompress(f *testing.F) {
+	f.Add(celChunkFixture[ChunkCelDataSize+ChunkCelDimensionSize:])
+	f.Add(tilesetChunkFixture[len(tilesetChunkFixture)-243:])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := PixelsZlib(data)
+		p.Decompress()
+	})
+}
+
+func FuzzParseICCProfile(f *testing.F) {
+	f.Add(iccProfileFixture(srgbColorants, srgbCurveFixture))
+	f.Add(iccProfileFixture(displayP3Colorants, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x40\x00\xFF\xFF")))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := ParseICCProfile(data)
+		if err != nil {
+			return
+		}
+
+		conv := newColorConverter(p.TRC, &p.ToXYZ)
+		conv.convert([]byte{0x00, 0x80, 0xFF, 0xFF})
+	})
+}
+
+func FuzzReadPalette(f *testing.F) {
+	for _, format := range []PaletteFormat{PaletteFormatGPL, PaletteFormatPAL, PaletteFormatACT, PaletteFormatASE, PaletteFormatTXT, PaletteFormatHex} {
+		var buf bytes.Buffer
+		WritePalette(&buf, Palette{{NRGBA: color.NRGBA{R: 0xFF, A: 0x80}, Name: "Red"}}, format)
+		f.Add(uint8(format), buf.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, format uint8, data []byte) {
+		ReadPalette(bytes.NewReader(data), PaletteFormat(format%6))
+	})
+}
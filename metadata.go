@@ -0,0 +1,155 @@
+package ase
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Metadata is what ReadMetadata reads from a file: the header, the first
+// frame without its cels and the duration of every frame.
+type Metadata struct {
+	Header   Header
+	Layers   []*Layer
+	Tags     []*Tag
+	Slices   []*Slice
+	Tilesets []*Tileset
+	UserData *ChunkUserData
+	// Palette is the palette of the first frame.
+	Palette Palette
+	// ColorProfile is the *ChunkColorProfile or *ChunkColorProfileICC of the
+	// file, nil when it has none.
+	ColorProfile Chunk
+	Durations    []time.Duration
+	Warnings     []Warning
+}
+
+// ReadMetadata reads the metadata of a file without decoding its pixels.
+// Cel data in the first frame is seeked past, and every later frame is
+// skipped whole through FrameHeader.FrameBytes, so palette changes and cels
+// of later frames are not read. Files that end before their last frame fail
+// with io.ErrUnexpectedEOF, as they do with DeserializeFile.
+func ReadMetadata(r io.ReadSeeker) (*Metadata, error) {
+	// seeking past the end of the file succeeds, so where each frame ends is
+	// checked against the size of the file instead
+	size, err := streamSize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ase := new(AsepriteFile)
+	loader := &Loader{
+		Reader:  r,
+		Buf:     make([]byte, ChunkSize),
+		Buffer:  new(bytes.Buffer),
+		File:    ase,
+		Options: DecodeOptions{Pixels: PixelModeSkip},
+	}
+
+	header, err := loader.ParseHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loader.Options.checkHeader(header); err != nil {
+		return nil, err
+	}
+	ase.Header = header
+
+	if header.Frames > 0 {
+		first := header
+		first.Frames = 1
+		if ase.Frames, err = loader.ParseFrames(&first); err != nil {
+			return nil, err
+		}
+		if err := loader.checkEnd(size, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	durations := make([]time.Duration, header.Frames)
+	for i := range header.Frames {
+		var fh FrameHeader
+		if i == 0 {
+			fh = ase.Frames[0].Header
+		} else {
+			if fh, err = loader.skipFrame(int(i)); err != nil {
+				return nil, err
+			}
+			if err := loader.checkEnd(size, int(i)); err != nil {
+				return nil, err
+			}
+		}
+
+		duration := fh.FrameDuration
+		if duration == 0 {
+			duration = header.FrameSpeed
+		}
+		durations[i] = time.Duration(duration) * time.Millisecond
+	}
+
+	sprite, err := ase.Sprite()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metadata{
+		Header:       header,
+		Layers:       sprite.Layers,
+		Tags:         sprite.Tags,
+		Slices:       sprite.Slices,
+		Tilesets:     sprite.Tilesets,
+		UserData:     sprite.UserData,
+		Palette:      sprite.Palette,
+		ColorProfile: sprite.ColorProfile,
+		Durations:    durations,
+		Warnings:     ase.Warnings,
+	}, nil
+}
+
+// skipFrame reads a frame header and moves past the rest of the frame.
+func (l *Loader) skipFrame(frameId int) (FrameHeader, error) {
+	fh, err := BytesToStruct[FrameHeader](l, FrameHeaderSize)
+	if err != nil {
+		return fh, err
+	}
+
+	if err := checkMagicNumber(0xF1FA, fh.MagicNumber, "frameheader "+fmt.Sprint(frameId)); err != nil {
+		return fh, err
+	}
+
+	if fh.FrameBytes < FrameHeaderSize {
+		return fh, fmt.Errorf("frame %d: %w (got %d bytes)", frameId, ErrInvalidChunkSize, fh.FrameBytes)
+	}
+
+	return fh, l.skip(int64(fh.FrameBytes) - FrameHeaderSize)
+}
+
+// streamSize returns how many bytes are left in r, leaving it where it was.
+func streamSize(r io.Seeker) (int64, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return end - start, nil
+}
+
+// checkEnd reports a frame that was skipped past the size of the file.
+func (l *Loader) checkEnd(size int64, frameId int) error {
+	if past := l.offset() - size; past > 0 {
+		return fmt.Errorf("frame %d: %w (ends %d bytes past the end of the file)", frameId, io.ErrUnexpectedEOF, past)
+	}
+
+	return nil
+}
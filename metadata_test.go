@@ -0,0 +1,202 @@
+package ase
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+type chunkFixture struct {
+	typ  ChunkDataType
+	body []byte
+}
+
+// fileFixture builds an RGBA file holding the given chunks in each frame.
+func fileFixture(width, height int, frames [][]chunkFixture) []byte {
+	var body bytes.Buffer
+	for _, chunks := range frames {
+		var frame bytes.Buffer
+		for _, c := range chunks {
+			binary.Write(&frame, binary.LittleEndian, ChunkHeader{Size: uint32(len(c.body)) + ChunkHeaderSize, Type: c.typ})
+			frame.Write(c.body)
+		}
+
+		binary.Write(&body, binary.LittleEndian, FrameHeader{
+			FrameBytes:     uint32(FrameHeaderSize + frame.Len()),
+			MagicNumber:    0xF1FA,
+			OldChunkNumber: uint16(len(chunks)),
+			FrameDuration:  100,
+			ChunkNumber:    uint32(len(chunks)),
+		})
+		body.Write(frame.Bytes())
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, Header{
+		FileSize:    uint32(HeaderSize + body.Len()),
+		MagicNumber: 0xA5E0,
+		Frames:      uint16(len(frames)),
+		Width:       uint16(width),
+		Height:      uint16(height),
+		ColorDepth:  ColorDepthRGBA,
+		FrameSpeed:  100,
+	})
+	out.Write(body.Bytes())
+
+	return out.Bytes()
+}
+
+// pixelsFixture returns the compressed pixels of a size x size RGBA image
+// whose colors depend on seed.
+func pixelsFixture(size, seed int) []byte {
+	pix := make([]byte, size*size*4)
+	for j := range size * size {
+		x, y := j%size, j/size
+		pix[j*4], pix[j*4+1], pix[j*4+2], pix[j*4+3] = byte(x^seed), byte(y*x), byte(y+seed), 0xFF
+	}
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(pix)
+	w.Close()
+
+	return compressed.Bytes()
+}
+
+// celFixture returns the body of a compressed size x size RGBA cel whose
+// colors depend on seed.
+func celFixture(size, seed int) []byte {
+	cel := make([]byte, ChunkCelDataSize)
+	cel[6] = 0xFF // opacity
+	cel[7] = byte(CelTypeCompressedImage)
+	cel = binary.LittleEndian.AppendUint16(cel, uint16(size))
+	cel = binary.LittleEndian.AppendUint16(cel, uint16(size))
+	return append(cel, pixelsFixture(size, seed)...)
+}
+
+// animationFixture builds a file of frames frames holding one compressed
+// size x size RGBA cel each, with a layer and a tag in the first frame.
+func animationFixture(frames, size int) []byte {
+	chunks := make([][]chunkFixture, frames)
+	for i := range chunks {
+		chunks[i] = []chunkFixture{{CelChunkHex, celFixture(size, i)}}
+	}
+	chunks[0] = append([]chunkFixture{{LayerChunkHex, layerChunkFixture}, {TagsChunkHex, tagChunkFixture}}, chunks[0]...)
+
+	return fileFixture(size, size, chunks)
+}
+
+// countingReadSeeker counts the bytes read through it.
+type countingReadSeeker struct {
+	io.ReadSeeker
+	read int
+}
+
+func (r *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestReadMetadata(t *testing.T) {
+	fd, err := os.Open(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to open file %s: %v", testFilePath, err)
+	}
+	defer fd.Close()
+
+	meta, err := ReadMetadata(fd)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+
+	sprite := loadTestSprite(t)
+	if meta.Header != sprite.File.Header || len(meta.Layers) != len(sprite.Layers) || len(meta.Palette) != len(sprite.Palette) {
+		t.Errorf("unexpected metadata: %d layers, %d colors", len(meta.Layers), len(meta.Palette))
+	}
+
+	if meta.Layers[0].Name() != "slime" || meta.Layers[0].UserData == nil || meta.Layers[0].UserData.Text != "teste=1" {
+		t.Errorf("unexpected layer: %q with user data %v", meta.Layers[0].Name(), meta.Layers[0].UserData)
+	}
+
+	if len(meta.Durations) != 8 || meta.Durations[7] != 250*time.Millisecond {
+		t.Errorf("unexpected durations: %v", meta.Durations)
+	}
+
+	data := animationFixture(64, 64)
+	r := &countingReadSeeker{ReadSeeker: bytes.NewReader(data)}
+	meta, err = ReadMetadata(r)
+	if err != nil {
+		t.Fatalf("failed to read metadata of the animation: %v", err)
+	}
+
+	if len(meta.Durations) != 64 || len(meta.Tags) != 1 || meta.Tags[0].Name != "Run" || meta.Layers[0].Name() != "Layer" {
+		t.Errorf("unexpected animation metadata: %d frames, %d tags, %d layers", len(meta.Durations), len(meta.Tags), len(meta.Layers))
+	}
+
+	// the cel data is seeked past, only the headers and the metadata chunks
+	// of the first frame are read
+	if r.read > len(data)/100 {
+		t.Errorf("read %d bytes of a %d bytes file", r.read, len(data))
+	}
+
+	ase, err := DeserializeFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode the animation: %v", err)
+	}
+	if len(ase.Frames) != 64 || len(ase.Warnings) != 0 {
+		t.Errorf("unexpected animation: %d frames, warnings %v", len(ase.Frames), ase.Warnings)
+	}
+	// truncated in the first frame and in a later one
+	single := animationFixture(1, 64)
+	for _, truncated := range [][]byte{single[:len(single)-100], data[:len(data)-100]} {
+		if _, err := ReadMetadata(bytes.NewReader(truncated)); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected io.ErrUnexpectedEOF for a file truncated to %d bytes, got %v", len(truncated), err)
+		}
+	}
+}
+
+func benchmarkAnimation(b *testing.B, read func(r io.ReadSeeker) error) {
+	data := animationFixture(256, 256)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if err := read(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeAnimation(b *testing.B) {
+	benchmarkAnimation(b, func(r io.ReadSeeker) error {
+		_, err := DeserializeFile(r)
+		return err
+	})
+}
+
+func BenchmarkDecodeAnimationWorkers(b *testing.B) {
+	benchmarkAnimation(b, func(r io.ReadSeeker) error {
+		_, err := Decode(r, DecodeOptions{Workers: -1})
+		return err
+	})
+}
+
+func BenchmarkDecodeAnimationSkipPixels(b *testing.B) {
+	benchmarkAnimation(b, func(r io.ReadSeeker) error {
+		_, err := Decode(r, DecodeOptions{Pixels: PixelModeSkip})
+		return err
+	})
+}
+
+func BenchmarkReadMetadataAnimation(b *testing.B) {
+	benchmarkAnimation(b, func(r io.ReadSeeker) error {
+		_, err := ReadMetadata(r)
+		return err
+	})
+}
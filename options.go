@@ -0,0 +1,191 @@
+package ase
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	DefaultMaxCanvasWidth       = 16384
+	DefaultMaxCanvasHeight      = 16384
+	DefaultMaxFrames            = 16384
+	DefaultMaxChunkSize         = 64 << 20
+	DefaultMaxDecompressedBytes = 512 << 20
+	DefaultMaxUserDataDepth     = 32
+)
+
+// PixelMode selects when the pixel data of image cels is decoded.
+type PixelMode int
+
+const (
+	// PixelModeEager decodes every cel while the file is read.
+	PixelModeEager PixelMode = iota
+	// PixelModeLazy keeps the pixel data as stored in the file and decodes
+	// a cel the first time ChunkCelImage.LoadPixels is called. The Pixels
+	// field of the cels stays nil.
+	PixelModeLazy
+	// PixelModeSkip reads only metadata and skips past the pixel data of
+	// cels and tilesets.
+	PixelModeSkip
+)
+
+// DecodeOptions controls how a file is decoded. Every limit uses its
+// default when left at zero and is disabled when negative, so the zero
+// value is safe to use on untrusted input.
+type DecodeOptions struct {
+	// MaxCanvasWidth and MaxCanvasHeight bound the canvas as well as every
+	// cel and tileset image.
+	MaxCanvasWidth  int
+	MaxCanvasHeight int
+	MaxFrames       int
+	// MaxChunkSize bounds ChunkHeader.Size, which in turn bounds every count
+	// declared inside a chunk (tags, slice keys, property maps...).
+	MaxChunkSize int64
+	// MaxDecompressedBytes bounds the sum of all zlib payloads of a file.
+	MaxDecompressedBytes int64
+	// MaxUserDataDepth bounds how deep user data vectors and property maps
+	// may nest.
+	MaxUserDataDepth int
+
+	// Strict turns quirks that are tolerated by default into errors: cel
+	// data that does not match the cel dimensions, chunk or frame sizes that
+	// disagree with their content, a wrong header file size and trailing
+	// data.
+	Strict bool
+
+	// Pixels selects when cel pixels are decoded. Lazily decoded cels check
+	// their data against the limits and Strict when they are loaded, drawing
+	// on the MaxDecompressedBytes budget of the file they were read from.
+	Pixels PixelMode
+
+	// Workers is how many goroutines decompress and convert the image cels
+	// of an eagerly decoded file while the rest of it is read. 0 and 1
+	// decode each cel as it is read, a negative value uses
+	// runtime.GOMAXPROCS. Workers share the MaxDecompressedBytes budget,
+	// reserving it before they allocate.
+	Workers int
+}
+
+// Warning describes a quirk that lenient decoding tolerated.
+type Warning struct {
+	Frame   int
+	Chunk   ChunkDataType
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("frame %d, chunk 0x%04X: %s", w.Frame, uint16(w.Chunk), w.Message)
+}
+
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError is returned when a file goes over one of the DecodeOptions
+// limits. It matches ErrLimitExceeded with errors.Is.
+type LimitError struct {
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s (got %d, max %d)", e.Limit, ErrLimitExceeded, e.Value, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+func limitOrDefault[T int | int64](v, def T) T {
+	if v == 0 {
+		return def
+	}
+
+	return v
+}
+
+func checkLimit[T int | int64](limit string, value, max T) error {
+	if max >= 0 && value > max {
+		return &LimitError{Limit: limit, Value: int64(value), Max: int64(max)}
+	}
+
+	return nil
+}
+
+func (o DecodeOptions) checkHeader(h Header) error {
+	if err := checkLimit("MaxCanvasWidth", int(h.Width), limitOrDefault(o.MaxCanvasWidth, DefaultMaxCanvasWidth)); err != nil {
+		return err
+	}
+
+	if err := checkLimit("MaxCanvasHeight", int(h.Height), limitOrDefault(o.MaxCanvasHeight, DefaultMaxCanvasHeight)); err != nil {
+		return err
+	}
+
+	return checkLimit("MaxFrames", int(h.Frames), limitOrDefault(o.MaxFrames, DefaultMaxFrames))
+}
+
+// checkImage applies the canvas limits to the size of a cel or tileset
+// image, which the header doesn't bound.
+func (o DecodeOptions) checkImage(width, height int64) error {
+	if err := checkLimit("MaxCanvasWidth", width, int64(limitOrDefault(o.MaxCanvasWidth, DefaultMaxCanvasWidth))); err != nil {
+		return err
+	}
+
+	return checkLimit("MaxCanvasHeight", height, int64(limitOrDefault(o.MaxCanvasHeight, DefaultMaxCanvasHeight)))
+}
+
+func (o DecodeOptions) checkChunk(ch ChunkHeader) error {
+	return checkLimit("MaxChunkSize", int64(ch.Size), limitOrDefault(o.MaxChunkSize, DefaultMaxChunkSize))
+}
+
+// budget counts the bytes decompressed for a file against
+// MaxDecompressedBytes. Bytes are reserved before they are allocated, so the
+// workers decoding cels in parallel can share it without going over.
+type budget struct {
+	// max is negative when there is no limit.
+	max  int64
+	used atomic.Int64
+}
+
+func newBudget(max int64) *budget {
+	return &budget{max: max}
+}
+
+// grant reserves up to n bytes and returns how many were reserved, fewer
+// than n once the budget runs out.
+func (b *budget) grant(n int64) int64 {
+	for {
+		used := b.used.Load()
+		got := n
+		if b.max >= 0 {
+			got = min(got, b.max-used)
+		}
+		if got <= 0 {
+			return 0
+		}
+
+		if b.used.CompareAndSwap(used, used+got) {
+			return got
+		}
+	}
+}
+
+// reserve reserves exactly n bytes, or fails with a *LimitError.
+func (b *budget) reserve(n int64) error {
+	if got := b.grant(n); got < n {
+		b.release(got)
+		return b.exceeded(n)
+	}
+
+	return nil
+}
+
+// release gives back n reserved bytes that were not used.
+func (b *budget) release(n int64) {
+	b.used.Add(-n)
+}
+
+// exceeded returns the error of needing n more bytes than are left.
+func (b *budget) exceeded(n int64) error {
+	return &LimitError{Limit: "MaxDecompressedBytes", Value: b.used.Load() + n, Max: b.max}
+}
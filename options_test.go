@@ -0,0 +1,460 @@
+package ase
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeLimits(t *testing.T) {
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+
+	tests := []struct {
+		limit string
+		opts  DecodeOptions
+	}{
+		{"MaxCanvasWidth", DecodeOptions{MaxCanvasWidth: 16}},
+		{"MaxCanvasHeight", DecodeOptions{MaxCanvasHeight: 16}},
+		{"MaxFrames", DecodeOptions{MaxFrames: 2}},
+		{"MaxChunkSize", DecodeOptions{MaxChunkSize: 32}},
+		{"MaxDecompressedBytes", DecodeOptions{MaxDecompressedBytes: 100}},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(data), tt.opts)
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: expected ErrLimitExceeded, got %v", tt.limit, err)
+			continue
+		}
+
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != tt.limit {
+			t.Errorf("unexpected limit error: got %v, want %s", err, tt.limit)
+		}
+	}
+
+	disabled := DecodeOptions{MaxCanvasWidth: -1, MaxCanvasHeight: -1, MaxFrames: -1, MaxChunkSize: -1, MaxDecompressedBytes: -1, MaxUserDataDepth: -1}
+	if _, err := Decode(bytes.NewReader(data), disabled); err != nil {
+		t.Errorf("failed to decode with limits disabled: %v", err)
+	}
+}
+
+func TestImageLimits(t *testing.T) {
+	// a cel declaring 65535x65535 pixels, past the default canvas limits
+	cel := bytes.Clone(celChunkFixture)
+	cel[16], cel[17], cel[18], cel[19] = 0xFF, 0xFF, 0xFF, 0xFF
+
+	file := &AsepriteFile{Header: Header{Width: 36, Height: 36, ColorDepth: ColorDepthRGBA}}
+	loader := &Loader{Buffer: bytes.NewBuffer(cel), File: file}
+	_, err := loader.ParseChunkCel(ChunkHeader{Size: uint32(len(cel)) + ChunkHeaderSize, Type: CelChunkHex}, 0)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxCanvasWidth" {
+		t.Errorf("expected a MaxCanvasWidth error for the cel, got %v", err)
+	}
+
+	// the tileset image stacks its 2 tiles of 16x16 pixels
+	tileset := tilesetChunkFixture
+	loader = &Loader{Buffer: bytes.NewBuffer(tileset), File: file, Options: DecodeOptions{MaxCanvasHeight: 31}}
+	_, err = loader.ParseChunkTileset(ChunkHeader{Size: uint32(len(tileset)) + ChunkHeaderSize, Type: TilesetChunkHex})
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxCanvasHeight" || limitErr.Value != 32 {
+		t.Errorf("expected a MaxCanvasHeight error for the tileset, got %v", err)
+	}
+
+	loader = &Loader{Buffer: bytes.NewBuffer(tileset), File: file, Options: DecodeOptions{MaxCanvasHeight: 32}}
+	if _, err := loader.ParseChunkTileset(ChunkHeader{Size: uint32(len(tileset)) + ChunkHeaderSize, Type: TilesetChunkHex}); err != nil {
+		t.Errorf("failed to parse a tileset within the limits: %v", err)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	compressed := new(bytes.Buffer)
+	w := zlib.NewWriter(compressed)
+	w.Write(make([]byte, 1<<20))
+	w.Close()
+
+	p := PixelsZlib(compressed.Bytes())
+	if _, err := p.DecompressLimit(1 << 10); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+
+	d, err := p.DecompressLimit(1 << 20)
+	if err != nil {
+		t.Fatalf("failed to decompress: %v", err)
+	}
+
+	if len(d) != 1<<20 {
+		t.Errorf("unexpected decompressed size: got %d, want %d", len(d), 1<<20)
+	}
+}
+
+func TestDecompressBudget(t *testing.T) {
+	b := newBudget(1000)
+
+	// workers reserving at the same time never get more than the budget
+	var granted atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n := b.grant(64)
+				if n == 0 {
+					return
+				}
+				granted.Add(n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if granted.Load() != 1000 {
+		t.Errorf("granted %d bytes of a budget of 1000", granted.Load())
+	}
+	if err := b.reserve(1); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+
+	b.release(10)
+	if err := b.reserve(10); err != nil {
+		t.Errorf("failed to reserve released bytes: %v", err)
+	}
+}
+
+func TestUserDataDepthLimit(t *testing.T) {
+	data := []byte{
+		0x04, 0x00, 0x00, 0x00, // Flags = properties
+		0x00, 0x00, 0x00, 0x00, // Size of all maps
+		0x01, 0x00, 0x00, 0x00, // Number of maps = 1
+		0x00, 0x00, 0x00, 0x00, // Map key = 0
+		0x01, 0x00, 0x00, 0x00, // Number of properties = 1
+		0x01, 0x00, 'v', // Name = "v"
+	}
+
+	// a vector holding a vector holding a vector...
+	for range 4 {
+		data = append(data,
+			0x11, 0x00, // Type = vector
+			0x01, 0x00, 0x00, 0x00, // Number of elements = 1
+			0x00, 0x00, // Element type = mixed
+		)
+	}
+	data = append(data, 0x01, 0x00, 0x01) // bool true
+
+	chunkHeader := ChunkHeader{
+		Size: uint32(len(data)) + ChunkHeaderSize,
+		Type: UserDataChunkHex,
+	}
+
+	loader := &Loader{Buffer: bytes.NewBuffer(data), Options: DecodeOptions{MaxUserDataDepth: 3}}
+	if _, err := loader.ParseChunkUserData(chunkHeader); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+
+	loader = &Loader{Buffer: bytes.NewBuffer(data), Options: DecodeOptions{MaxUserDataDepth: 4}}
+	if _, err := loader.ParseChunkUserData(chunkHeader); err != nil {
+		t.Errorf("failed to parse ChunkUserData: %v", err)
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+
+	ase, err := Decode(bytes.NewReader(data), DecodeOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("failed to decode %s in strict mode: %v", testFilePath, err)
+	}
+
+	if len(ase.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", ase.Warnings)
+	}
+}
+
+func TestDecodeQuirks(t *testing.T) {
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+
+	putUint32 := func(b []byte, v uint32) {
+		b[0], b[1], b[2], b[3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+	}
+
+	// the layer chunk of the first frame starts after the frame header, the
+	// color profile chunk and the old palette chunk
+	const layerOffset = HeaderSize + FrameHeaderSize + 22 + 106
+	padded := append([]byte(nil), data[:layerOffset+29]...)
+	padded = append(padded, 0, 0, 0, 0)
+	padded = append(padded, data[layerOffset+29:]...)
+	putUint32(padded, uint32(len(padded)))
+	putUint32(padded[HeaderSize:], 461+4)
+	putUint32(padded[layerOffset:], 29+4)
+
+	wrongSize := append([]byte(nil), data...)
+	putUint32(wrongSize, 1234)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"trailing data", append(append([]byte(nil), data...), 0xFF, 0xFF), ErrTrailingData},
+		{"file size", wrongSize, ErrSizeMismatch},
+		{"chunk size", padded, ErrSizeMismatch},
+	}
+
+	for _, tt := range tests {
+		if _, err := Decode(bytes.NewReader(tt.data), DecodeOptions{Strict: true}); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v in strict mode, got %v", tt.name, tt.err, err)
+		}
+
+		ase, err := Decode(bytes.NewReader(tt.data), DecodeOptions{})
+		if err != nil {
+			t.Errorf("%s: failed to decode in lenient mode: %v", tt.name, err)
+			continue
+		}
+
+		if len(ase.Warnings) != 1 {
+			t.Errorf("%s: unexpected warnings: got %v, want 1 warning", tt.name, ase.Warnings)
+		}
+
+		verifyFrames(t, ase)
+	}
+
+	// an error reading past the last frame is not the end of the file
+	errRead := errors.New("read failed")
+	r := io.MultiReader(bytes.NewReader(data), iotest.ErrReader(errRead))
+	if _, err := Decode(r, DecodeOptions{}); !errors.Is(err, errRead) {
+		t.Errorf("expected the read error after the last frame, got %v", err)
+	}
+}
+
+func TestDecodeOldChunkNumber(t *testing.T) {
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+
+	// clear the new chunk count of the first frame, leaving the old one
+	data = append([]byte(nil), data...)
+	copy(data[HeaderSize+12:HeaderSize+16], []byte{0, 0, 0, 0})
+
+	ase, err := Decode(bytes.NewReader(data), DecodeOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+
+	verifyFrames(t, ase)
+}
+
+func TestDecodePixelModes(t *testing.T) {
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to read file %s: %v", testFilePath, err)
+	}
+
+	decode := func(r io.Reader, mode PixelMode) *Sprite {
+		t.Helper()
+
+		ase, err := Decode(r, DecodeOptions{Strict: true, Pixels: mode})
+		if err != nil {
+			t.Fatalf("mode %d: failed to decode: %v", mode, err)
+		}
+
+		sprite, err := ase.Sprite()
+		if err != nil {
+			t.Fatalf("mode %d: failed to build sprite: %v", mode, err)
+		}
+
+		return sprite
+	}
+
+	eager := decode(bytes.NewReader(data), PixelModeEager)
+	want := renderAll(t, eager)
+
+	lazy := decode(bytes.NewReader(data), PixelModeLazy)
+	chunk := lazy.Frames[0].Cels[0].Chunk.(*ChunkCelImage)
+	if chunk.Pixels != nil {
+		t.Errorf("expected a lazy cel to leave Pixels nil, got %T", chunk.Pixels)
+	}
+
+	for i, img := range renderAll(t, lazy) {
+		if !bytes.Equal(img.Pix, want[i].Pix) {
+			t.Errorf("frame %d differs between lazy and eager decoding", i)
+		}
+	}
+
+	// io.Reader hides the Seek method of bytes.Reader
+	for _, r := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+		skipped := decode(r, PixelModeSkip)
+		if len(skipped.Frames) != len(eager.Frames) || len(skipped.Layers) != len(eager.Layers) || skipped.Layers[0].UserData == nil {
+			t.Fatalf("unexpected metadata with skipped pixels: %d frames, %d layers", len(skipped.Frames), len(skipped.Layers))
+		}
+
+		chunk := skipped.Frames[0].Cels[0].Chunk.(*ChunkCelImage)
+		if chunk.Width != eager.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Width {
+			t.Errorf("unexpected cel width with skipped pixels: %d", chunk.Width)
+		}
+
+		if _, err := chunk.LoadPixels(); !errors.Is(err, ErrSkippedPixels) {
+			t.Errorf("expected ErrSkippedPixels, got %v", err)
+		}
+
+		if _, err := skipped.RenderFrame(0, RenderOptions{}); !errors.Is(err, ErrSkippedPixels) {
+			t.Errorf("expected ErrSkippedPixels from RenderFrame, got %v", err)
+		}
+	}
+}
+
+func TestDecodePixelModesLimits(t *testing.T) {
+	// lazy cels share the budget of their file: 4 cels of 16x16 pixels
+	// don't fit in the size of 2
+	lazy, err := Decode(bytes.NewReader(animationFixture(4, 16)), DecodeOptions{Pixels: PixelModeLazy, MaxDecompressedBytes: 16 * 16 * 4 * 2})
+	if err != nil {
+		t.Fatalf("failed to decode lazily: %v", err)
+	}
+
+	var loaded int
+	for _, frame := range lazy.Frames {
+		for _, c := range frame.Chunks {
+			if cel, ok := c.(*ChunkCelImage); ok {
+				if _, err := cel.LoadPixels(); err == nil {
+					loaded++
+				} else if !errors.Is(err, ErrLimitExceeded) {
+					t.Errorf("expected ErrLimitExceeded, got %v", err)
+				}
+			}
+		}
+	}
+	if loaded != 2 {
+		t.Errorf("unexpected number of lazy cels within the budget: got %d, want 2", loaded)
+	}
+
+	// the image of a skipped tileset is seeked past, not read
+	pixels := pixelsFixture(128, 1)
+	var tileset bytes.Buffer
+	binary.Write(&tileset, binary.LittleEndian, ChunkTilesetData{FlagsBit: 2, TilesNumber: 1, TileWidth: 128, TileHeight: 128})
+	binary.Write(&tileset, binary.LittleEndian, uint32(len(pixels)))
+	tileset.Write(pixels)
+	data := fileFixture(16, 16, [][]chunkFixture{{{TilesetChunkHex, tileset.Bytes()}, {LayerChunkHex, layerChunkFixture}}})
+
+	r := &countingReadSeeker{ReadSeeker: bytes.NewReader(data)}
+	ase, err := Decode(r, DecodeOptions{Strict: true, Pixels: PixelModeSkip})
+	if err != nil {
+		t.Fatalf("failed to decode with skipped pixels: %v", err)
+	}
+
+	chunks := ase.Frames[0].Chunks
+	if len(chunks) != 2 || chunks[0].(*ChunkTileset).TileWidth != 128 || chunks[0].(*ChunkTileset).TilesetImage != nil {
+		t.Fatalf("unexpected chunks with skipped pixels: %v", chunks)
+	}
+	if r.read >= len(pixels) {
+		t.Errorf("expected the tileset image to be skipped, read %d of %d bytes", r.read, len(data))
+	}
+}
+
+func TestDecodeWorkers(t *testing.T) {
+	// cels of frames 1 and 3 inflate to more rows than they declare, and
+	// frame 2 has a layer chunk with a padded body
+	padded := append(append([]byte(nil), layerChunkFixture...), 0, 0)
+	frames := make([][]chunkFixture, 6)
+	for i := range frames {
+		frames[i] = []chunkFixture{{CelChunkHex, celFixture(8, i)}}
+	}
+	frames[1] = append(frames[1], chunkFixture{CelChunkHex, celChunkFixture})
+	frames[2] = append(frames[2], chunkFixture{LayerChunkHex, padded})
+	frames[3] = append(frames[3], chunkFixture{CelChunkHex, celChunkFixture})
+	data := fileFixture(36, 36, frames)
+
+	want, err := Decode(bytes.NewReader(data), DecodeOptions{})
+	if err != nil {
+		t.Fatalf("failed to decode serially: %v", err)
+	}
+
+	if len(want.Warnings) != 3 {
+		t.Fatalf("unexpected warnings: %v", want.Warnings)
+	}
+
+	for _, workers := range []int{2, 4, -1} {
+		got, err := Decode(bytes.NewReader(data), DecodeOptions{Workers: workers})
+		if err != nil {
+			t.Fatalf("%d workers: failed to decode: %v", workers, err)
+		}
+
+		if !reflect.DeepEqual(got.Warnings, want.Warnings) {
+			t.Errorf("%d workers: got warnings %v, want %v", workers, got.Warnings, want.Warnings)
+		}
+
+		for i, frame := range got.Frames {
+			for j, c := range frame.Chunks {
+				if !reflect.DeepEqual(c, want.Frames[i].Chunks[j]) {
+					t.Errorf("%d workers: chunk %d of frame %d differs from serial decoding", workers, j, i)
+				}
+			}
+		}
+
+		_, err = Decode(bytes.NewReader(data), DecodeOptions{Workers: workers, Strict: true})
+		if !errors.Is(err, ErrInvalidPixels) {
+			t.Errorf("%d workers: expected ErrInvalidPixels in strict mode, got %v", workers, err)
+		}
+
+		_, err = Decode(bytes.NewReader(data), DecodeOptions{Workers: workers, MaxDecompressedBytes: 8 * 8 * 4 * 3})
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%d workers: expected ErrLimitExceeded, got %v", workers, err)
+		}
+	}
+}
+
+// cancelingReader cancels its context once more than after bytes were read.
+type cancelingReader struct {
+	io.Reader
+	after  int
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if r.after -= n; r.after < 0 {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestDecodeContext(t *testing.T) {
+	data := animationFixture(16, 16)
+
+	for _, workers := range []int{0, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := DecodeContext(ctx, bytes.NewReader(data), DecodeOptions{Workers: workers}); !errors.Is(err, context.Canceled) {
+			t.Errorf("%d workers: expected context.Canceled, got %v", workers, err)
+		}
+
+		// canceled halfway through the frames
+		ctx, cancel = context.WithCancel(context.Background())
+		r := &cancelingReader{Reader: bytes.NewReader(data), after: len(data) / 2, cancel: cancel}
+		if _, err := DecodeContext(ctx, r, DecodeOptions{Workers: workers}); !errors.Is(err, context.Canceled) {
+			t.Errorf("%d workers: expected context.Canceled halfway, got %v", workers, err)
+		}
+		cancel()
+
+		if _, err := DecodeContext(context.Background(), bytes.NewReader(data), DecodeOptions{Workers: workers}); err != nil {
+			t.Errorf("%d workers: failed to decode: %v", workers, err)
+		}
+	}
+}
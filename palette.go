@@ -0,0 +1,179 @@
+package ase
+
+import "image/color"
+
+type PaletteEntry struct {
+	color.NRGBA
+	Name string
+}
+
+// Palette is an indexed color table. Convert and Index behave like the
+// color.Palette methods of the same name, ColorPalette returns the table as
+// a color.Palette for image.Paletted.
+type Palette []PaletteEntry
+
+// Convert returns the palette color closest to c in Euclidean RGBA space.
+func (p Palette) Convert(c color.Color) color.Color {
+	if len(p) == 0 {
+		return nil
+	}
+
+	return p[p.Index(c)].NRGBA
+}
+
+// Index returns the index of the palette color closest to c in Euclidean
+// RGBA space.
+func (p Palette) Index(c color.Color) int {
+	cr, cg, cb, ca := c.RGBA()
+
+	ret, bestSum := 0, uint32(1<<32-1)
+	for i, e := range p {
+		vr, vg, vb, va := e.RGBA()
+		sum := sqDiff(cr, vr) + sqDiff(cg, vg) + sqDiff(cb, vb) + sqDiff(ca, va)
+		if sum < bestSum {
+			if sum == 0 {
+				return i
+			}
+			ret, bestSum = i, sum
+		}
+	}
+
+	return ret
+}
+
+// sqDiff returns the squared difference of two 16-bit color components,
+// scaled down like image/color does so four of them fit in a uint32.
+func sqDiff(x, y uint32) uint32 {
+	d := x - y
+	return (d * d) >> 2
+}
+
+func (p Palette) ColorPalette() color.Palette {
+	colors := make(color.Palette, len(p))
+	for i, e := range p {
+		colors[i] = e.NRGBA
+	}
+
+	return colors
+}
+
+// apply returns a copy of the palette with the entries of a palette or old
+// palette chunk written over it.
+func (p Palette) apply(chunk Chunk) Palette {
+	switch c := chunk.(type) {
+	case *ChunkPalette:
+		// EntriesNumber is the new size of the whole palette
+		out := make(Palette, max(int(c.EntriesNumber), int(c.From)+len(c.Entries)))
+		copy(out, p)
+		for i, e := range c.Entries {
+			out[int(c.From)+i] = PaletteEntry{
+				NRGBA: color.NRGBA{R: e.Red, G: e.Green, B: e.Blue, A: e.Alpha},
+				Name:  e.ColorName,
+			}
+		}
+		return out
+	case *ChunkOldPalette2:
+		return p.applyOld(c.ChunkOldPalette, true)
+	case *ChunkOldPalette:
+		return p.applyOld(c, false)
+	}
+
+	return p
+}
+
+// applyOld writes the entries set by the packets of an old palette chunk,
+// or all of its colors when it has no packets. sixBit scales the 0-63
+// values of a ChunkOldPalette2.
+func (p Palette) applyOld(c *ChunkOldPalette, sixBit bool) Palette {
+	out := make(Palette, max(len(p), len(c.Colors)))
+	copy(out, p)
+
+	set := func(i int) {
+		old := c.Colors[i]
+		if sixBit {
+			old = ChunkOldPaletteColor{R: scale6Bit(old.R), G: scale6Bit(old.G), B: scale6Bit(old.B)}
+		}
+		out[i].NRGBA = color.NRGBA{R: old.R, G: old.G, B: old.B, A: 0xFF}
+	}
+
+	if c.Packets == nil {
+		for i := range c.Colors {
+			set(i)
+		}
+		return out
+	}
+
+	index := 0
+	for _, packet := range c.Packets {
+		index += int(packet.PaletteEntriesNumber)
+
+		colorsNumber := int(packet.ColorsNumber)
+		if colorsNumber == 0 {
+			colorsNumber = 256
+		}
+
+		for range colorsNumber {
+			if index < len(c.Colors) {
+				set(index)
+			}
+			index++
+		}
+	}
+
+	return out
+}
+
+// scale6Bit maps 0-63 to 0-255, repeating the high bits so 63 becomes 255.
+func scale6Bit(v byte) byte {
+	v = min(v, 63)
+	return v<<2 | v>>4
+}
+
+// newPaletteChunk returns a palette chunk setting every entry of p, with its
+// size filled in.
+func newPaletteChunk(p Palette) *ChunkPalette {
+	c := &ChunkPalette{
+		header: ChunkHeader{Type: PaletteChunkHex, Size: ChunkHeaderSize + ChunkPaletteDataSize},
+		ChunkPaletteData: ChunkPaletteData{
+			EntriesNumber: uint32(len(p)),
+			To:            uint32(max(len(p)-1, 0)),
+		},
+	}
+
+	for _, e := range p {
+		c.Entries = append(c.Entries, ChunkPaletteEntry{Red: e.R, Green: e.G, Blue: e.B, Alpha: e.A, ColorName: e.Name})
+		c.header.Size += ChunkPaletteEntryDataSize
+		if e.Name != "" {
+			c.header.Size += 2 + uint32(len(e.Name))
+		}
+	}
+
+	return c
+}
+
+// framePalette applies the palette chunks of a frame to prev. Old palette
+// chunks are only used when the frame has no new palette chunk, since
+// Aseprite writes both for compatibility.
+func framePalette(prev Palette, chunks []Chunk) Palette {
+	hasNew := false
+	for _, chunk := range chunks {
+		if _, ok := chunk.(*ChunkPalette); ok {
+			hasNew = true
+			break
+		}
+	}
+
+	p := prev
+	for _, chunk := range chunks {
+		switch chunk.(type) {
+		case *ChunkPalette:
+			p = p.apply(chunk)
+		case *ChunkOldPalette, *ChunkOldPalette2:
+			if !hasNew {
+				p = p.apply(chunk)
+			}
+		}
+	}
+
+	return p
+}
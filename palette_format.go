@@ -0,0 +1,578 @@
+package ase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var ErrInvalidPaletteFormat = errors.New("invalid palette format")
+
+type PaletteFormat int
+
+const (
+	// PaletteFormatGPL is a GIMP palette, RGBA when it has a "Channels: RGBA"
+	// line as written by Aseprite.
+	PaletteFormatGPL PaletteFormat = iota
+	// PaletteFormatPAL is a JASC (Paint Shop Pro) palette.
+	PaletteFormatPAL
+	// PaletteFormatACT is an Adobe Color Table, up to 256 colors.
+	PaletteFormatACT
+	// PaletteFormatASE is an Adobe Swatch Exchange file. RGB, CMYK, gray and
+	// Lab swatches are read, swatches are written as RGB.
+	PaletteFormatASE
+	// PaletteFormatTXT is a Paint.NET palette, one AARRGGBB value per line.
+	PaletteFormatTXT
+	// PaletteFormatHex is a list of RRGGBB values, one per line, with
+	// RRGGBBAA used for colors that aren't opaque.
+	PaletteFormatHex
+)
+
+func (f PaletteFormat) String() string {
+	switch f {
+	case PaletteFormatGPL:
+		return "gpl"
+	case PaletteFormatPAL:
+		return "pal"
+	case PaletteFormatACT:
+		return "act"
+	case PaletteFormatASE:
+		return "ase"
+	case PaletteFormatTXT:
+		return "txt"
+	case PaletteFormatHex:
+		return "hex"
+	}
+
+	return fmt.Sprintf("PaletteFormat(%d)", int(f))
+}
+
+const (
+	// paletteMaxSize bounds the palette files read.
+	paletteMaxSize = 16 << 20
+	// paletteMaxColors bounds the colors of the palette files read.
+	paletteMaxColors = 1 << 16
+)
+
+// ReadPalette reads a palette file in the given format. Files over 16 MiB
+// or with more than 65536 colors are rejected.
+func ReadPalette(r io.Reader, format PaletteFormat) (Palette, error) {
+	switch format {
+	case PaletteFormatGPL:
+		return readPaletteGPL(r)
+	case PaletteFormatPAL:
+		return readPalettePAL(r)
+	case PaletteFormatACT:
+		return readPaletteACT(r)
+	case PaletteFormatASE:
+		return readPaletteASE(r)
+	case PaletteFormatTXT, PaletteFormatHex:
+		return readPaletteHex(r, format)
+	}
+
+	return nil, fmt.Errorf("palette: %w (%v)", ErrInvalidPaletteFormat, format)
+}
+
+// WritePalette writes p in the given format. Names are kept by the formats
+// that have them, alpha by GPL, PAL, TXT and Hex.
+func WritePalette(w io.Writer, p Palette, format PaletteFormat) error {
+	bw := bufio.NewWriter(w)
+
+	var err error
+	switch format {
+	case PaletteFormatGPL:
+		err = writePaletteGPL(bw, p)
+	case PaletteFormatPAL:
+		err = writePalettePAL(bw, p)
+	case PaletteFormatACT:
+		err = writePaletteACT(bw, p)
+	case PaletteFormatASE:
+		err = writePaletteASE(bw, p)
+	case PaletteFormatTXT, PaletteFormatHex:
+		err = writePaletteHex(bw, p, format)
+	default:
+		err = fmt.Errorf("palette: %w (%v)", ErrInvalidPaletteFormat, format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+func (p Palette) hasAlpha() bool {
+	for _, e := range p {
+		if e.A != 0xFF {
+			return true
+		}
+	}
+
+	return false
+}
+
+// paletteLines calls fn with each line of r that isn't blank, trimmed, and
+// its line number.
+func paletteLines(r io.Reader, format PaletteFormat, fn func(line string, n int) error) error {
+	limited := &io.LimitedReader{R: r, N: paletteMaxSize + 1}
+	scanner := bufio.NewScanner(limited)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		if line == "" {
+			continue
+		}
+
+		if err := fn(line, n); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if limited.N == 0 {
+		return fmt.Errorf("%v: %w (larger than %d bytes)", format, ErrInvalidPaletteFormat, paletteMaxSize)
+	}
+
+	return nil
+}
+
+// appendColor appends e to p, failing once p holds paletteMaxColors.
+func appendColor(p Palette, e PaletteEntry, format PaletteFormat) (Palette, error) {
+	if len(p) == paletteMaxColors {
+		return p, fmt.Errorf("%v: %w (more than %d colors)", format, ErrInvalidPaletteFormat, paletteMaxColors)
+	}
+
+	return append(p, e), nil
+}
+
+// parseComponents reads the first n fields as color components.
+func parseComponents(fields []string, n int) ([]uint8, bool) {
+	if len(fields) < n {
+		return nil, false
+	}
+
+	components := make([]uint8, n)
+	for i := range n {
+		v, err := strconv.ParseUint(fields[i], 10, 8)
+		if err != nil {
+			return nil, false
+		}
+		components[i] = uint8(v)
+	}
+
+	return components, true
+}
+
+func readPaletteGPL(r io.Reader) (Palette, error) {
+	var p Palette
+	channels := 3
+
+	err := paletteLines(r, PaletteFormatGPL, func(line string, n int) error {
+		switch {
+		case n == 1:
+			if line != "GIMP Palette" {
+				return fmt.Errorf("gpl: %w (missing header)", ErrInvalidPaletteFormat)
+			}
+			return nil
+		case strings.HasPrefix(line, "#"), strings.HasPrefix(line, "Name:"), strings.HasPrefix(line, "Columns:"):
+			return nil
+		case strings.HasPrefix(line, "Channels:"):
+			if strings.TrimSpace(strings.TrimPrefix(line, "Channels:")) == "RGBA" {
+				channels = 4
+			}
+			return nil
+		}
+
+		fields := strings.Fields(line)
+		c, ok := parseComponents(fields, channels)
+		if !ok {
+			return fmt.Errorf("gpl: %w (line %d: %q)", ErrInvalidPaletteFormat, n, line)
+		}
+
+		e := PaletteEntry{NRGBA: color.NRGBA{R: c[0], G: c[1], B: c[2], A: 0xFF}, Name: strings.Join(fields[channels:], " ")}
+		if channels == 4 {
+			e.A = c[3]
+		}
+
+		var err error
+		p, err = appendColor(p, e, PaletteFormatGPL)
+		return err
+	})
+
+	return p, err
+}
+
+var gplNameReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+func writePaletteGPL(w *bufio.Writer, p Palette) error {
+	alpha := p.hasAlpha()
+
+	w.WriteString("GIMP Palette\n")
+	if alpha {
+		w.WriteString("Channels: RGBA\n")
+	}
+	w.WriteString("#\n")
+
+	for _, e := range p {
+		// a name ends at the end of its line
+		name := gplNameReplacer.Replace(e.Name)
+		if alpha {
+			fmt.Fprintf(w, "%3d %3d %3d %3d\t%s\n", e.R, e.G, e.B, e.A, name)
+		} else {
+			fmt.Fprintf(w, "%3d %3d %3d\t%s\n", e.R, e.G, e.B, name)
+		}
+	}
+
+	return nil
+}
+
+func readPalettePAL(r io.Reader) (Palette, error) {
+	var p Palette
+	count := -1
+
+	err := paletteLines(r, PaletteFormatPAL, func(line string, n int) error {
+		switch {
+		case n == 1:
+			if line != "JASC-PAL" {
+				return fmt.Errorf("pal: %w (missing header)", ErrInvalidPaletteFormat)
+			}
+			return nil
+		case n == 2:
+			return nil
+		case count < 0:
+			v, err := strconv.Atoi(line)
+			if err != nil || v < 0 || v > paletteMaxColors {
+				return fmt.Errorf("pal: %w (line %d: invalid color count %q)", ErrInvalidPaletteFormat, n, line)
+			}
+			count = v
+			return nil
+		case len(p) == count:
+			return nil
+		}
+
+		fields := strings.Fields(line)
+		c, ok := parseComponents(fields, min(len(fields), 4))
+		if !ok || len(c) < 3 {
+			return fmt.Errorf("pal: %w (line %d: %q)", ErrInvalidPaletteFormat, n, line)
+		}
+
+		e := PaletteEntry{NRGBA: color.NRGBA{R: c[0], G: c[1], B: c[2], A: 0xFF}}
+		if len(c) == 4 {
+			e.A = c[3]
+		}
+
+		var err error
+		p, err = appendColor(p, e, PaletteFormatPAL)
+		return err
+	})
+
+	if err == nil && len(p) != count {
+		err = fmt.Errorf("pal: %w (got %d colors, want %d)", ErrInvalidPaletteFormat, len(p), count)
+	}
+
+	return p, err
+}
+
+func writePalettePAL(w *bufio.Writer, p Palette) error {
+	alpha := p.hasAlpha()
+
+	fmt.Fprintf(w, "JASC-PAL\r\n0100\r\n%d\r\n", len(p))
+	for _, e := range p {
+		if alpha {
+			fmt.Fprintf(w, "%d %d %d %d\r\n", e.R, e.G, e.B, e.A)
+		} else {
+			fmt.Fprintf(w, "%d %d %d\r\n", e.R, e.G, e.B)
+		}
+	}
+
+	return nil
+}
+
+const (
+	actColors = 256
+	actSize   = actColors * 3
+)
+
+func readPaletteACT(r io.Reader) (Palette, error) {
+	data, err := io.ReadAll(io.LimitReader(r, actSize+5))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != actSize && len(data) != actSize+4 {
+		return nil, fmt.Errorf("act: %w (size %d)", ErrInvalidPaletteFormat, len(data))
+	}
+
+	count, transparent := actColors, -1
+	if len(data) == actSize+4 {
+		if n := int(binary.BigEndian.Uint16(data[actSize:])); n > 0 && n <= actColors {
+			count = n
+		}
+		if i := binary.BigEndian.Uint16(data[actSize+2:]); i != 0xFFFF {
+			transparent = int(i)
+		}
+	}
+
+	p := make(Palette, count)
+	for i := range p {
+		p[i].NRGBA = color.NRGBA{R: data[i*3], G: data[i*3+1], B: data[i*3+2], A: 0xFF}
+		if i == transparent {
+			p[i].A = 0
+		}
+	}
+
+	return p, nil
+}
+
+func writePaletteACT(w *bufio.Writer, p Palette) error {
+	// a count of 0 reads back as 256 colors
+	if len(p) == 0 || len(p) > actColors {
+		return fmt.Errorf("act: %w (%d colors, from 1 to %d fit)", ErrInvalidPaletteFormat, len(p), actColors)
+	}
+
+	data := make([]byte, actSize+4)
+	transparent := 0xFFFF
+	for i, e := range p {
+		data[i*3], data[i*3+1], data[i*3+2] = e.R, e.G, e.B
+		if e.A == 0 && transparent == 0xFFFF {
+			transparent = i
+		}
+	}
+	binary.BigEndian.PutUint16(data[actSize:], uint16(len(p)))
+	binary.BigEndian.PutUint16(data[actSize+2:], uint16(transparent))
+
+	_, err := w.Write(data)
+	return err
+}
+
+const (
+	aseSwatchBlockColor      = 0x0001
+	aseSwatchBlockGroupStart = 0xC001
+	aseSwatchBlockGroupEnd   = 0xC002
+	aseSwatchColorNormal     = 2
+)
+
+func readPaletteASE(r io.Reader) (Palette, error) {
+	data, err := io.ReadAll(io.LimitReader(r, paletteMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > paletteMaxSize {
+		return nil, fmt.Errorf("ase: %w (larger than %d bytes)", ErrInvalidPaletteFormat, paletteMaxSize)
+	}
+
+	if len(data) < 12 || string(data[:4]) != "ASEF" {
+		return nil, fmt.Errorf("ase: %w (missing header)", ErrInvalidPaletteFormat)
+	}
+
+	var p Palette
+	blocks := binary.BigEndian.Uint32(data[8:])
+	data = data[12:]
+
+	for range blocks {
+		if len(data) < 6 {
+			return nil, fmt.Errorf("ase: %w (truncated block)", ErrInvalidPaletteFormat)
+		}
+
+		typ := binary.BigEndian.Uint16(data)
+		length := binary.BigEndian.Uint32(data[2:])
+		if uint64(length) > uint64(len(data)-6) {
+			return nil, fmt.Errorf("ase: %w (block of %d bytes)", ErrInvalidPaletteFormat, length)
+		}
+
+		block := data[6 : 6+length]
+		data = data[6+length:]
+
+		if typ != aseSwatchBlockColor {
+			continue
+		}
+
+		e, err := parseASESwatch(block)
+		if err != nil {
+			return nil, err
+		}
+		if p, err = appendColor(p, e, PaletteFormatASE); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func parseASESwatch(block []byte) (PaletteEntry, error) {
+	invalid := fmt.Errorf("ase: %w (bad color block)", ErrInvalidPaletteFormat)
+
+	if len(block) < 2 {
+		return PaletteEntry{}, invalid
+	}
+
+	nameLen := int(binary.BigEndian.Uint16(block))
+	if len(block) < 2+nameLen*2+4 {
+		return PaletteEntry{}, invalid
+	}
+
+	name := make([]uint16, nameLen)
+	for i := range name {
+		name[i] = binary.BigEndian.Uint16(block[2+i*2:])
+	}
+	if nameLen > 0 && name[nameLen-1] == 0 {
+		name = name[:nameLen-1]
+	}
+
+	block = block[2+nameLen*2:]
+	model := string(block[:4])
+	block = block[4:]
+
+	values := make([]float64, 0, 4)
+	for len(block) >= 4 && len(values) < 4 {
+		values = append(values, float64(math.Float32frombits(binary.BigEndian.Uint32(block))))
+		block = block[4:]
+	}
+
+	e := PaletteEntry{Name: string(utf16.Decode(name))}
+	switch {
+	case model == "RGB " && len(values) >= 3:
+		e.NRGBA = color.NRGBA{R: toByte(values[0]), G: toByte(values[1]), B: toByte(values[2]), A: 0xFF}
+	case model == "Gray" && len(values) >= 1:
+		v := toByte(values[0])
+		e.NRGBA = color.NRGBA{R: v, G: v, B: v, A: 0xFF}
+	case model == "CMYK" && len(values) >= 4:
+		k := 1 - values[3]
+		e.NRGBA = color.NRGBA{
+			R: toByte((1 - values[0]) * k),
+			G: toByte((1 - values[1]) * k),
+			B: toByte((1 - values[2]) * k),
+			A: 0xFF,
+		}
+	case model == "LAB " && len(values) >= 3:
+		e.NRGBA = labToNRGBA(values[0]*100, values[1], values[2])
+	default:
+		return PaletteEntry{}, fmt.Errorf("ase: %w (color model %q)", ErrInvalidPaletteFormat, model)
+	}
+
+	return e, nil
+}
+
+// labToNRGBA converts a CIE Lab color with a D50 white point to sRGB.
+func labToNRGBA(l, a, b float64) color.NRGBA {
+	const epsilon, kappa = 216.0 / 24389, 24389.0 / 27
+	white := [3]float64{0.9642, 1, 0.8249}
+
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+
+	inverse := func(f float64) float64 {
+		if f*f*f > epsilon {
+			return f * f * f
+		}
+		return (116*f - 16) / kappa
+	}
+	xyz := [3]float64{inverse(fx) * white[0], inverse(fy) * white[1], inverse(fz) * white[2]}
+
+	var rgb [3]uint8
+	for i := range 3 {
+		v := xyzD50ToSRGB[i][0]*xyz[0] + xyzD50ToSRGB[i][1]*xyz[1] + xyzD50ToSRGB[i][2]*xyz[2]
+		rgb[i] = toByte(srgbEncode(clamp01(v)))
+	}
+
+	return color.NRGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xFF}
+}
+
+func writePaletteASE(w *bufio.Writer, p Palette) error {
+	w.WriteString("ASEF")
+	binary.Write(w, binary.BigEndian, [2]uint16{1, 0})
+	binary.Write(w, binary.BigEndian, uint32(len(p)))
+
+	for _, e := range p {
+		name := append(utf16.Encode([]rune(e.Name)), 0)
+		if len(name) > math.MaxUint16 {
+			return fmt.Errorf("ase: %w (color name too long)", ErrInvalidPaletteFormat)
+		}
+
+		block := binary.BigEndian.AppendUint16(nil, uint16(len(name)))
+		for _, u := range name {
+			block = binary.BigEndian.AppendUint16(block, u)
+		}
+		block = append(block, "RGB "...)
+		for _, c := range []uint8{e.R, e.G, e.B} {
+			block = binary.BigEndian.AppendUint32(block, math.Float32bits(float32(c)/255))
+		}
+		block = binary.BigEndian.AppendUint16(block, aseSwatchColorNormal)
+
+		binary.Write(w, binary.BigEndian, uint16(aseSwatchBlockColor))
+		binary.Write(w, binary.BigEndian, uint32(len(block)))
+		w.Write(block)
+	}
+
+	return nil
+}
+
+// readPaletteHex reads Paint.NET AARRGGBB lines, skipping ';' comments, or
+// RRGGBB[AA] lines with an optional '#' for the hex format.
+func readPaletteHex(r io.Reader, format PaletteFormat) (Palette, error) {
+	var p Palette
+
+	err := paletteLines(r, format, func(line string, n int) error {
+		if format == PaletteFormatTXT && strings.HasPrefix(line, ";") {
+			return nil
+		}
+		if format == PaletteFormatHex {
+			line = strings.TrimPrefix(line, "#")
+		}
+
+		v, err := strconv.ParseUint(line, 16, 32)
+		if err != nil || (len(line) != 6 && len(line) != 8) || (format == PaletteFormatTXT && len(line) != 8) {
+			return fmt.Errorf("%v: %w (line %d: %q)", format, ErrInvalidPaletteFormat, n, line)
+		}
+
+		var c color.NRGBA
+		switch {
+		case format == PaletteFormatTXT:
+			c = color.NRGBA{A: uint8(v >> 24), R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}
+		case len(line) == 8:
+			c = color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}
+		default:
+			c = color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}
+		}
+		p, err = appendColor(p, PaletteEntry{NRGBA: c}, format)
+		return err
+	})
+
+	return p, err
+}
+
+func writePaletteHex(w *bufio.Writer, p Palette, format PaletteFormat) error {
+	if format == PaletteFormatTXT {
+		w.WriteString("; paint.net Palette File\n")
+		w.WriteString("; Lines that start with a semicolon are comments\n")
+		for _, e := range p {
+			fmt.Fprintf(w, "%02X%02X%02X%02X\n", e.A, e.R, e.G, e.B)
+		}
+		return nil
+	}
+
+	for _, e := range p {
+		if e.A != 0xFF {
+			fmt.Fprintf(w, "%02x%02x%02x%02x\n", e.R, e.G, e.B, e.A)
+		} else {
+			fmt.Fprintf(w, "%02x%02x%02x\n", e.R, e.G, e.B)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package ase
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunkPaletteMerge(t *testing.T) {
+	loader := &Loader{Buffer: bytes.NewBuffer(paletteChunkFixture)}
+	chunk, err := loader.ParseChunkPalette(ChunkHeader{Size: uint32(len(paletteChunkFixture)) + ChunkHeaderSize, Type: PaletteChunkHex})
+	if err != nil {
+		t.Fatalf("failed to parse ChunkPalette: %v", err)
+	}
+
+	old := &ChunkOldPalette{header: ChunkHeader{Type: OldPaletteChunkHex}, Colors: make([]ChunkOldPaletteColor, 256)}
+	update := &ChunkPalette{
+		header:           ChunkHeader{Type: PaletteChunkHex},
+		ChunkPaletteData: ChunkPaletteData{EntriesNumber: 4, From: 3, To: 3},
+		Entries:          []ChunkPaletteEntry{{Red: 0x10, Green: 0x20, Blue: 0x30, Alpha: 0xFF}},
+	}
+
+	p := framePalette(nil, []Chunk{old, chunk})
+	want := Palette{
+		{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}},
+		{NRGBA: color.NRGBA{G: 0xFF, A: 0x80}},
+		{NRGBA: color.NRGBA{B: 0xFF, A: 0xFF}, Name: "azulao"},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("unexpected palette: got %v, want %v", p, want)
+	}
+
+	merged := framePalette(p, []Chunk{update})
+	if len(merged) != 4 || merged[3].NRGBA != (color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xFF}) || merged[2] != p[2] {
+		t.Errorf("unexpected merged palette: %v", merged)
+	}
+
+	if len(p) != 3 {
+		t.Errorf("expected merging to leave the previous palette untouched, got %v", p)
+	}
+
+	if oldOnly := framePalette(nil, []Chunk{old}); len(oldOnly) != 256 || oldOnly[0].A != 0xFF {
+		t.Errorf("unexpected palette from old palette chunk: %d entries", len(oldOnly))
+	}
+
+	if i := p.Index(color.RGBA{R: 0xF0, G: 0x10, A: 0xFF}); i != 0 {
+		t.Errorf("unexpected closest index: got %d, want %d", i, 0)
+	}
+
+	if c := p.Convert(color.NRGBA{B: 0xE0, A: 0xFF}); c != p[2].NRGBA {
+		t.Errorf("unexpected closest color: got %v, want %v", c, p[2].NRGBA)
+	}
+
+	if cp := p.ColorPalette(); len(cp) != 3 || cp.Index(p[1].NRGBA) != 1 {
+		t.Errorf("unexpected color.Palette: %v", cp)
+	}
+}
+
+func TestPaletteFormats(t *testing.T) {
+	p := Palette{
+		{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}, Name: "Red"},
+		{NRGBA: color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xFF}, Name: "Deep Blue"},
+		{NRGBA: color.NRGBA{G: 0xFF, A: 0x80}, Name: "Glass"},
+	}
+
+	opaque := append(Palette(nil), p...)
+	opaque[2].A = 0xFF
+
+	unnamed := func(p Palette) Palette {
+		out := append(Palette(nil), p...)
+		for i := range out {
+			out[i].Name = ""
+		}
+		return out
+	}
+
+	tests := []struct {
+		format PaletteFormat
+		in     Palette
+		want   Palette
+	}{
+		{PaletteFormatGPL, p, p},
+		{PaletteFormatGPL, opaque, opaque},
+		{PaletteFormatPAL, p, unnamed(p)},
+		{PaletteFormatACT, opaque, unnamed(opaque)},
+		{PaletteFormatASE, opaque, opaque},
+		{PaletteFormatTXT, p, unnamed(p)},
+		{PaletteFormatHex, p, unnamed(p)},
+		// a GPL name ends at the end of its line
+		{PaletteFormatGPL, Palette{{NRGBA: color.NRGBA{A: 0xFF}, Name: "Two\nLines"}}, Palette{{NRGBA: color.NRGBA{A: 0xFF}, Name: "Two Lines"}}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WritePalette(&buf, tt.in, tt.format); err != nil {
+			t.Fatalf("%v: failed to write palette: %v", tt.format, err)
+		}
+
+		got, err := ReadPalette(&buf, tt.format)
+		if err != nil {
+			t.Fatalf("%v: failed to read palette: %v", tt.format, err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: unexpected round trip: got %v, want %v", tt.format, got, tt.want)
+		}
+	}
+	// ACT reads a count of 0 as 256 colors, so it can't hold an empty palette
+	if err := WritePalette(io.Discard, Palette{}, PaletteFormatACT); !errors.Is(err, ErrInvalidPaletteFormat) {
+		t.Errorf("expected ErrInvalidPaletteFormat for an empty ACT palette, got %v", err)
+	}
+}
+
+func TestReadPalette(t *testing.T) {
+	act := make([]byte, 772)
+	copy(act, []byte{0xFF, 0x00, 0x00, 0x00, 0xFF, 0x00})
+	act[769], act[771] = 2, 1 // 2 colors, the second transparent
+
+	aseLab := []byte("ASEF\x00\x01\x00\x00\x00\x00\x00\x01" +
+		"\x00\x01\x00\x00\x00\x12" + // color block, 18 bytes
+		"\x00\x00" + "LAB " + // no name
+		"\x3F\x80\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" + // L = 1, a = 0, b = 0
+		"\x00\x02")
+
+	tests := []struct {
+		name   string
+		format PaletteFormat
+		data   string
+		want   Palette
+	}{
+		{"gpl", PaletteFormatGPL, "GIMP Palette\nName: Test\nColumns: 4\n#\n255   0   0\tRed Light\n  0 128 255\n", Palette{
+			{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}, Name: "Red Light"},
+			{NRGBA: color.NRGBA{G: 0x80, B: 0xFF, A: 0xFF}},
+		}},
+		{"pal", PaletteFormatPAL, "JASC-PAL\r\n0100\r\n1\r\n1 2 3\r\n", Palette{{NRGBA: color.NRGBA{R: 1, G: 2, B: 3, A: 0xFF}}}},
+		{"act", PaletteFormatACT, string(act), Palette{{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}}, {NRGBA: color.NRGBA{G: 0xFF}}}},
+		{"ase lab", PaletteFormatASE, string(aseLab), Palette{{NRGBA: color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}}}},
+		{"txt", PaletteFormatTXT, "; paint.net\n80FF0000\n", Palette{{NRGBA: color.NRGBA{R: 0xFF, A: 0x80}}}},
+		{"hex", PaletteFormatHex, "#ff0000\n00ff0080\n", Palette{{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}}, {NRGBA: color.NRGBA{G: 0xFF, A: 0x80}}}},
+	}
+
+	for _, tt := range tests {
+		got, err := ReadPalette(strings.NewReader(tt.data), tt.format)
+		if err != nil {
+			t.Errorf("%s: failed to read palette: %v", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	for _, data := range []string{"GIMP\n", "GIMP Palette\n1 2\n", "JASC-PAL\n0100\n2\n1 2 3\n"} {
+		format := PaletteFormatGPL
+		if strings.HasPrefix(data, "JASC") {
+			format = PaletteFormatPAL
+		}
+		if _, err := ReadPalette(strings.NewReader(data), format); !errors.Is(err, ErrInvalidPaletteFormat) {
+			t.Errorf("%q: expected ErrInvalidPaletteFormat, got %v", data, err)
+		}
+	}
+
+	huge := io.MultiReader(strings.NewReader(string(aseLab)), io.LimitReader(zeroReader{}, paletteMaxSize))
+	if _, err := ReadPalette(huge, PaletteFormatASE); !errors.Is(err, ErrInvalidPaletteFormat) {
+		t.Errorf("expected ErrInvalidPaletteFormat for a swatch file over %d bytes, got %v", paletteMaxSize, err)
+	}
+
+	// text files are bounded like swatch files, in size and in colors
+	spaces := io.MultiReader(strings.NewReader("GIMP Palette\n"), io.LimitReader(newlineReader{}, paletteMaxSize))
+	if _, err := ReadPalette(spaces, PaletteFormatGPL); !errors.Is(err, ErrInvalidPaletteFormat) {
+		t.Errorf("expected ErrInvalidPaletteFormat for a palette file over %d bytes, got %v", paletteMaxSize, err)
+	}
+
+	colors := strings.Repeat("000000\n", paletteMaxColors+1)
+	if _, err := ReadPalette(strings.NewReader(colors), PaletteFormatHex); !errors.Is(err, ErrInvalidPaletteFormat) {
+		t.Errorf("expected ErrInvalidPaletteFormat for a palette of more than %d colors, got %v", paletteMaxColors, err)
+	}
+	if p, err := ReadPalette(strings.NewReader(colors[7:]), PaletteFormatHex); err != nil || len(p) != paletteMaxColors {
+		t.Errorf("failed to read a palette of %d colors: got %d colors, %v", paletteMaxColors, len(p), err)
+	}
+}
+
+// newlineReader reads blank lines forever.
+type newlineReader struct{}
+
+func (newlineReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = '\n'
+	}
+	return len(p), nil
+}
+
+// zeroReader reads zeros forever.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestOldPaletteChunks(t *testing.T) {
+	// one packet of 256 colors (count 0), all set to 63, 63, 63
+	data := []byte{0x01, 0x00, 0x00, 0x00}
+	for range 256 {
+		data = append(data, 63, 63, 63)
+	}
+
+	for _, typ := range []ChunkDataType{OldPaletteChunkHex, OldPaletteChunk2Hex} {
+		loader := &Loader{Buffer: bytes.NewBuffer(data)}
+		chunk, err := loader.ParseChunkOldPalette(ChunkHeader{Size: uint32(len(data)) + ChunkHeaderSize, Type: typ})
+		if err != nil {
+			t.Fatalf("0x%04X: failed to parse old palette: %v", typ, err)
+		}
+
+		if unread := loader.Buffer.Len(); unread != 0 {
+			t.Errorf("0x%04X: expected chunk to be fully read, but %d bytes remain", typ, unread)
+		}
+
+		want := color.NRGBA{R: 63, G: 63, B: 63, A: 0xFF}
+		if typ == OldPaletteChunk2Hex {
+			want = color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+		}
+
+		p := framePalette(nil, []Chunk{chunk})
+		if len(p) != 256 || p[0].NRGBA != want || p[255].NRGBA != want {
+			t.Errorf("0x%04X: unexpected palette: %d entries, first %v, want %v", typ, len(p), p[0].NRGBA, want)
+		}
+	}
+
+	// skipped entries keep the colors of the previous palette
+	prev := Palette{{NRGBA: color.NRGBA{R: 1, A: 0xFF}}, {NRGBA: color.NRGBA{R: 2, A: 0xFF}}, {NRGBA: color.NRGBA{R: 3, A: 0xFF}}}
+	loader := &Loader{Buffer: bytes.NewBuffer(oldPaletteChunkFixture)}
+	chunk, err := loader.ParseChunkOldPalette(ChunkHeader{Size: uint32(len(oldPaletteChunkFixture)) + ChunkHeaderSize, Type: OldPaletteChunkHex})
+	if err != nil {
+		t.Fatalf("failed to parse old palette: %v", err)
+	}
+
+	p := framePalette(prev, []Chunk{chunk})
+	if len(p) != 4 || p[2] != prev[2] || p[3].NRGBA != (color.NRGBA{B: 0xFF, A: 0xFF}) {
+		t.Errorf("unexpected palette: %v", p)
+	}
+}
@@ -0,0 +1,155 @@
+package ase
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// GrayAlpha is an in-memory image of gray and alpha pairs, the layout of
+// grayscale cels. Pix holds the gray value and then the alpha of each pixel,
+// neither premultiplied.
+type GrayAlpha struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+}
+
+func NewGrayAlpha(r image.Rectangle) *GrayAlpha {
+	return &GrayAlpha{Pix: make([]uint8, 2*r.Dx()*r.Dy()), Stride: 2 * r.Dx(), Rect: r}
+}
+
+func (p *GrayAlpha) ColorModel() color.Model {
+	return GrayAlphaModel
+}
+
+func (p *GrayAlpha) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+func (p *GrayAlpha) At(x, y int) color.Color {
+	return p.GrayAlphaAt(x, y)
+}
+
+func (p *GrayAlpha) GrayAlphaAt(x, y int) GrayAlphaColor {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return GrayAlphaColor{}
+	}
+
+	i := p.PixOffset(x, y)
+	return GrayAlphaColor{Y: p.Pix[i], A: p.Pix[i+1]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds
+// to the pixel at (x, y).
+func (p *GrayAlpha) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+}
+
+func (p *GrayAlpha) Set(x, y int, c color.Color) {
+	p.SetGrayAlpha(x, y, GrayAlphaModel.Convert(c).(GrayAlphaColor))
+}
+
+func (p *GrayAlpha) SetGrayAlpha(x, y int, c GrayAlphaColor) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	p.Pix[i], p.Pix[i+1] = c.Y, c.A
+}
+
+// SubImage returns an image representing the portion of p visible through
+// r. The returned value shares pixels with the original image.
+func (p *GrayAlpha) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &GrayAlpha{}
+	}
+
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &GrayAlpha{Pix: p.Pix[i:], Stride: p.Stride, Rect: r}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *GrayAlpha) Opaque() bool {
+	if p.Rect.Empty() {
+		return true
+	}
+
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for end := i + 2*p.Rect.Dx(); i < end; i += 2 {
+			if p.Pix[i+1] != 0xFF {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// GrayAlphaColor is a gray value with a non-premultiplied alpha.
+type GrayAlphaColor struct {
+	Y, A uint8
+}
+
+func (c GrayAlphaColor) RGBA() (r, g, b, a uint32) {
+	a = uint32(c.A) * 0x101
+	y := uint32(c.Y) * 0x101 * a / 0xFFFF
+	return y, y, y, a
+}
+
+// GrayAlphaModel converts colors to GrayAlphaColor with the luma weights of
+// color.GrayModel.
+var GrayAlphaModel color.Model = color.ModelFunc(grayAlphaModel)
+
+func grayAlphaModel(c color.Color) color.Color {
+	if c, ok := c.(GrayAlphaColor); ok {
+		return c
+	}
+
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	y := (19595*uint32(n.R) + 38470*uint32(n.G) + 7471*uint32(n.B) + 1<<15) >> 16
+	return GrayAlphaColor{Y: uint8(y), A: n.A}
+}
+
+// newPixels wraps buf, the pixel data of a width x height image, without
+// copying it: *image.NRGBA for RGBA, *GrayAlpha for grayscale and
+// *image.Paletted with palette for indexed data.
+func newPixels(buf []byte, depth ColorDepth, width, height int, palette color.Palette) (Pixels, error) {
+	bpp := depth.BytesPerPixel()
+	if bpp == 0 {
+		return nil, fmt.Errorf("pixels: %w %d", ErrInvalidColorDepth, depth)
+	}
+
+	if width < 0 || height < 0 || len(buf) != width*height*bpp {
+		return nil, fmt.Errorf("pixels: %w (got %d bytes, want %dx%d)", ErrInvalidPixels, len(buf), width, height)
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	switch depth {
+	case ColorDepthRGBA:
+		return &image.NRGBA{Pix: buf, Stride: width * 4, Rect: rect}, nil
+	case ColorDepthGrayscale:
+		return &GrayAlpha{Pix: buf, Stride: width * 2, Rect: rect}, nil
+	default:
+		return &image.Paletted{Pix: buf, Stride: width, Rect: rect, Palette: palette}, nil
+	}
+}
+
+// imagePalette returns p as the palette of an *image.Paletted: 256 colors
+// so every index is valid, the ones past p and the transparent index being
+// transparent.
+func imagePalette(p Palette, transparent int) color.Palette {
+	out := make(color.Palette, 256)
+	for i := range out {
+		if i < len(p) && i != transparent {
+			out[i] = p[i].NRGBA
+		} else {
+			out[i] = color.NRGBA{}
+		}
+	}
+
+	return out
+}
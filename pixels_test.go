@@ -0,0 +1,84 @@
+package ase
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestGrayAlpha(t *testing.T) {
+	img := NewGrayAlpha(image.Rect(0, 0, 2, 2))
+	img.SetGrayAlpha(0, 0, GrayAlphaColor{Y: 0x80, A: 0xFF})
+	img.Set(1, 0, color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0x80})
+
+	if c := img.GrayAlphaAt(1, 0); c != (GrayAlphaColor{Y: 0xFF, A: 0x80}) {
+		t.Errorf("unexpected color after Set: %v", c)
+	}
+
+	if img.Opaque() {
+		t.Errorf("expected an image with transparent pixels not to be opaque")
+	}
+
+	sub := img.SubImage(image.Rect(1, 0, 2, 1)).(*GrayAlpha)
+	if c := sub.GrayAlphaAt(1, 0); c != img.GrayAlphaAt(1, 0) || sub.GrayAlphaAt(0, 0) != (GrayAlphaColor{}) {
+		t.Errorf("unexpected sub image: %v", sub)
+	}
+
+	dst := image.NewNRGBA(img.Rect)
+	draw.Draw(dst, dst.Rect, img, image.Point{}, draw.Src)
+	if c := dst.NRGBAAt(0, 0); c != (color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF}) {
+		t.Errorf("unexpected drawn color: %v", c)
+	}
+	if c := dst.NRGBAAt(1, 0); c != (color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0x80}) {
+		t.Errorf("unexpected drawn color: %v", c)
+	}
+}
+
+func TestNewPixels(t *testing.T) {
+	buf := bytes.Repeat([]byte{1, 2, 3, 4}, 6)
+
+	tests := []struct {
+		depth  ColorDepth
+		width  int
+		height int
+	}{
+		{ColorDepthRGBA, 3, 2},
+		{ColorDepthGrayscale, 4, 3},
+		{ColorDepthIndexed, 6, 4},
+	}
+
+	for _, tt := range tests {
+		p, err := newPixels(buf, tt.depth, tt.width, tt.height, imagePalette(nil, 0))
+		if err != nil {
+			t.Fatalf("depth %d: failed to wrap pixels: %v", tt.depth, err)
+		}
+
+		var pix []byte
+		switch img := p.(type) {
+		case *image.NRGBA:
+			pix = img.Pix
+		case *GrayAlpha:
+			pix = img.Pix
+		case *image.Paletted:
+			pix = img.Pix
+			// every index is in range of the palette
+			img.At(0, 0)
+		}
+
+		if &pix[0] != &buf[0] || p.(image.Image).Bounds() != image.Rect(0, 0, tt.width, tt.height) {
+			t.Errorf("depth %d: expected %T to wrap the buffer", tt.depth, p)
+		}
+
+		if _, err := newPixels(buf, tt.depth, tt.width+1, tt.height, nil); !errors.Is(err, ErrInvalidPixels) {
+			t.Errorf("depth %d: expected ErrInvalidPixels, got %v", tt.depth, err)
+		}
+	}
+
+	p := imagePalette(Palette{{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}}, {NRGBA: color.NRGBA{G: 0xFF, A: 0xFF}}}, 0)
+	if len(p) != 256 || p[0] != (color.NRGBA{}) || p[1] != (color.NRGBA{G: 0xFF, A: 0xFF}) || p[255] != (color.NRGBA{}) {
+		t.Errorf("unexpected image palette: %v", p[:2])
+	}
+}
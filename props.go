@@ -0,0 +1,114 @@
+package ase
+
+import "math"
+
+// Props is a user data properties map. Values keep the type they were stored
+// with: bool, the sized integer types, Fixed, float32, float64, string,
+// PropPoint, PropSize, PropRect, [16]byte for UUIDs, []any for vectors and
+// Props for nested maps. The accessors below convert between compatible
+// types so callers don't need to know the exact one.
+type Props map[string]any
+
+type PropPoint struct {
+	X int32
+	Y int32
+}
+
+type PropSize struct {
+	Width  int32
+	Height int32
+}
+
+type PropRect struct {
+	PropPoint
+	PropSize
+}
+
+// Int returns the value of key when it holds any integer type that fits in
+// an int64.
+func (p Props) Int(key string) (int64, bool) {
+	return propInt(p[key])
+}
+
+// Float returns the value of key when it holds a float, a Fixed or an
+// integer.
+func (p Props) Float(key string) (float64, bool) {
+	switch v := p[key].(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case Fixed:
+		return v.FixedToFloat(), true
+	}
+
+	i, ok := propInt(p[key])
+	return float64(i), ok
+}
+
+func (p Props) Bool(key string) (bool, bool) {
+	v, ok := p[key].(bool)
+	return v, ok
+}
+
+func (p Props) String(key string) (string, bool) {
+	v, ok := p[key].(string)
+	return v, ok
+}
+
+// Map returns the nested properties map stored under key.
+func (p Props) Map(key string) (Props, bool) {
+	v, ok := p[key].(Props)
+	return v, ok
+}
+
+func (p Props) Vector(key string) ([]any, bool) {
+	v, ok := p[key].([]any)
+	return v, ok
+}
+
+func (p Props) Point(key string) (PropPoint, bool) {
+	v, ok := p[key].(PropPoint)
+	return v, ok
+}
+
+func (p Props) Size(key string) (PropSize, bool) {
+	v, ok := p[key].(PropSize)
+	return v, ok
+}
+
+func (p Props) Rect(key string) (PropRect, bool) {
+	v, ok := p[key].(PropRect)
+	return v, ok
+}
+
+func (p Props) UUID(key string) ([16]byte, bool) {
+	v, ok := p[key].([16]byte)
+	return v, ok
+}
+
+func propInt(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int8:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+
+	return 0, false
+}
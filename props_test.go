@@ -0,0 +1,102 @@
+package ase
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkUserDataProperties(t *testing.T) {
+	data := []byte{
+		0x04, 0x00, 0x00, 0x00, // Flags = properties
+		0x00, 0x00, 0x00, 0x00, // Size of all maps (not checked)
+		0x02, 0x00, 0x00, 0x00, // Number of maps = 2
+
+		// user properties
+		0x00, 0x00, 0x00, 0x00, // Map key = 0
+		0x04, 0x00, 0x00, 0x00, // Number of properties = 4
+
+		0x04, 0x00, 'n', 'a', 'm', 'e', // Name = "name"
+		0x0D, 0x00, // Type = string
+		0x03, 0x00, 'o', 'r', 'c',
+
+		0x05, 0x00, 's', 't', 'a', 't', 's', // Name = "stats"
+		0x12, 0x00, // Type = properties map
+		0x01, 0x00, 0x00, 0x00, // Number of properties = 1
+		0x02, 0x00, 'h', 'p', // Name = "hp"
+		0x05, 0x00, // Type = uint16
+		0x2C, 0x01, // Value = 300
+
+		0x06, 0x00, 'h', 'i', 't', 'b', 'o', 'x', // Name = "hitbox"
+		0x10, 0x00, // Type = rect
+		0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, // X = 1, Y = 2
+		0x10, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, // Width = 16, Height = 32
+
+		0x05, 0x00, 's', 'p', 'e', 'e', 'd', // Name = "speed"
+		0x0A, 0x00, // Type = fixed
+		0x00, 0x80, 0x01, 0x00, // Value = 1.5
+
+		// extension properties
+		0x03, 0x00, 0x00, 0x00, // Map key = 3
+		0x01, 0x00, 0x00, 0x00, // Number of properties = 1
+		0x03, 0x00, 'p', 'o', 's', // Name = "pos"
+		0x0E, 0x00, // Type = point
+		0xFF, 0xFF, 0xFF, 0xFF, 0x07, 0x00, 0x00, 0x00, // X = -1, Y = 7
+	}
+
+	chunkHeader := ChunkHeader{
+		Size: uint32(len(data)) + ChunkHeaderSize,
+		Type: UserDataChunkHex,
+	}
+
+	loader := &Loader{Buffer: bytes.NewBuffer(data)}
+
+	chunk, err := loader.ParseChunkUserData(chunkHeader)
+	if err != nil {
+		t.Fatalf("failed to parse ChunkUserData: %v", err)
+	}
+
+	if unread := loader.Buffer.Len(); unread != 0 {
+		t.Errorf("expected ChunkUserData to be fully read, but %d bytes remain", unread)
+	}
+
+	userData := chunk.(*ChunkUserData)
+
+	if len(userData.Maps) != 2 {
+		t.Fatalf("unexpected number of maps: got %d, want %d", len(userData.Maps), 2)
+	}
+
+	props := userData.Properties()
+
+	if name, _ := props.String("name"); name != "orc" {
+		t.Errorf("unexpected name: got %q, want %q", name, "orc")
+	}
+
+	stats, ok := props.Map("stats")
+	if !ok {
+		t.Fatalf("expected a nested stats map, got %T", props["stats"])
+	}
+
+	if hp, _ := stats.Int("hp"); hp != 300 {
+		t.Errorf("unexpected hp: got %d, want %d", hp, 300)
+	}
+
+	if hitbox, _ := props.Rect("hitbox"); hitbox.X != 1 || hitbox.Y != 2 || hitbox.Width != 16 || hitbox.Height != 32 {
+		t.Errorf("unexpected hitbox: got %+v", hitbox)
+	}
+
+	if speed, _ := props.Float("speed"); speed != 1.5 {
+		t.Errorf("unexpected speed: got %f, want %f", speed, 1.5)
+	}
+
+	if _, ok := props.Int("name"); ok {
+		t.Errorf("expected Int to reject a string value")
+	}
+
+	if !userData.Maps[1].External {
+		t.Errorf("expected map with key 3 to be external")
+	}
+
+	if pos, _ := userData.ExtensionProperties(3).Point("pos"); pos.X != -1 || pos.Y != 7 {
+		t.Errorf("unexpected pos: got %+v", pos)
+	}
+}
@@ -0,0 +1,212 @@
+package ase
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+var ErrInvalidRemap = errors.New("invalid remap")
+
+// Remap recolors a sprite. Indexed sprites can use every field: Indexes
+// rewrites the pixels, the color pairs and Colors change palette entries.
+// RGBA sprites take the color pairs and Colors, matched against exact pixel
+// colors. Grayscale sprites can't be remapped.
+type Remap struct {
+	// Indexes maps palette indexes, indexes missing from it are kept.
+	Indexes map[uint8]uint8
+	// Source and Target are color pairs: Source[i] becomes Target[i].
+	Source Palette
+	Target Palette
+	// Colors maps exact colors.
+	Colors map[color.NRGBA]color.NRGBA
+}
+
+func (r *Remap) check(depth ColorDepth) error {
+	if len(r.Source) != len(r.Target) {
+		return fmt.Errorf("remap: %w (%d source colors, %d target colors)", ErrInvalidRemap, len(r.Source), len(r.Target))
+	}
+
+	switch depth {
+	case ColorDepthIndexed:
+		return nil
+	case ColorDepthRGBA:
+		if len(r.Indexes) > 0 {
+			return fmt.Errorf("remap: %w (index mapping on an RGBA sprite)", ErrInvalidRemap)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("remap: %w (%d)", ErrUnsupportedColorDepth, depth)
+}
+
+// colors returns the exact color mapping of Colors and the color pairs,
+// the first pair for a color winning over later ones and over Colors.
+func (r *Remap) colors() map[color.NRGBA]color.NRGBA {
+	if len(r.Source) == 0 {
+		return r.Colors
+	}
+
+	m := make(map[color.NRGBA]color.NRGBA, len(r.Colors)+len(r.Source))
+	for from, to := range r.Colors {
+		m[from] = to
+	}
+	for i := len(r.Source) - 1; i >= 0; i-- {
+		m[r.Source[i].NRGBA] = r.Target[i].NRGBA
+	}
+
+	return m
+}
+
+// palette returns p with its entries recolored by colors, the mapping
+// returned by r.colors.
+func (r *Remap) palette(p Palette, colors map[color.NRGBA]color.NRGBA) Palette {
+	if len(colors) == 0 {
+		return p
+	}
+
+	out := make(Palette, len(p))
+	for i, e := range p {
+		if to, ok := colors[e.NRGBA]; ok {
+			e.NRGBA = to
+		}
+		out[i] = e
+	}
+
+	return out
+}
+
+// pixels returns the remapped pixels of a cel, or nil when they don't
+// change. Indexed images get a recolored palette, sharing Pix with the cel
+// when the indexes stay the same.
+func (r *Remap) pixels(chunk *ChunkCelImage, colors map[color.NRGBA]color.NRGBA) (Pixels, error) {
+	if len(r.Indexes) == 0 && len(colors) == 0 {
+		return nil, nil
+	}
+
+	p, err := chunk.LoadPixels()
+	if err != nil {
+		return nil, err
+	}
+
+	switch img := p.(type) {
+	case *image.Paletted:
+		out := *img
+		if len(r.Indexes) > 0 {
+			out.Pix = make([]uint8, len(img.Pix))
+			for i, index := range img.Pix {
+				if to, ok := r.Indexes[index]; ok {
+					index = to
+				}
+				out.Pix[i] = index
+			}
+		}
+
+		if len(colors) > 0 {
+			out.Palette = make(color.Palette, len(img.Palette))
+			for i, c := range img.Palette {
+				if to, ok := colors[color.NRGBAModel.Convert(c).(color.NRGBA)]; ok {
+					c = to
+				}
+				out.Palette[i] = c
+			}
+		}
+		return &out, nil
+	case *image.NRGBA:
+		if len(colors) == 0 {
+			return nil, nil
+		}
+
+		out := *img
+		out.Pix = make([]uint8, len(img.Pix))
+		for i := 0; i+3 < len(img.Pix); i += 4 {
+			c := color.NRGBA{R: img.Pix[i], G: img.Pix[i+1], B: img.Pix[i+2], A: img.Pix[i+3]}
+			if to, ok := colors[c]; ok {
+				c = to
+			}
+			out.Pix[i], out.Pix[i+1], out.Pix[i+2], out.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		return &out, nil
+	}
+
+	return nil, nil
+}
+
+// Remap returns a recolored copy of the sprite. Cels whose pixels don't
+// change, like those of an indexed sprite recolored through its palette,
+// share their Pix with s.
+func (s *Sprite) Remap(r Remap) (*Sprite, error) {
+	if err := r.check(s.ColorDepth); err != nil {
+		return nil, err
+	}
+
+	colors := r.colors()
+
+	out := *s
+	out.Frames = make([]*SpriteFrame, len(s.Frames))
+	out.cache = nil
+	cels := map[*Cel]*Cel{}
+
+	// frames without palette chunks share the palette before them, keep
+	// sharing the recolored one
+	var from, to Palette
+
+	for i, frame := range s.Frames {
+		f := *frame
+		if s.ColorDepth == ColorDepthIndexed {
+			if len(frame.Palette) == 0 || len(from) == 0 || &frame.Palette[0] != &from[0] {
+				from, to = frame.Palette, r.palette(frame.Palette, colors)
+			}
+			f.Palette = to
+		}
+
+		f.Cels = make([]*Cel, len(frame.Cels))
+		for j, cel := range frame.Cels {
+			c := *cel
+			if chunk, ok := cel.Chunk.(*ChunkCelImage); ok {
+				pixels, err := r.pixels(chunk, colors)
+				if err != nil {
+					return nil, fmt.Errorf("remap: frame %d: %w", i, err)
+				}
+				if pixels != nil {
+					c.Chunk = chunk.withPixels(pixels)
+				}
+			}
+			f.Cels[j] = &c
+			cels[cel] = &c
+		}
+
+		out.Frames[i] = &f
+	}
+
+	for _, frame := range out.Frames {
+		for _, cel := range frame.Cels {
+			if cel.Link != nil {
+				cel.Link = cels[cel.Link]
+			}
+		}
+	}
+
+	if s.ColorDepth == ColorDepthIndexed {
+		out.Palette = r.palette(s.Palette, colors)
+	}
+
+	return &out, nil
+}
+
+// Variants returns one recolored copy of the sprite per target palette,
+// each mapping the colors of source to the colors of the target at the same
+// position.
+func (s *Sprite) Variants(source Palette, targets []Palette) ([]*Sprite, error) {
+	variants := make([]*Sprite, len(targets))
+	for i, target := range targets {
+		v, err := s.Remap(Remap{Source: source, Target: target})
+		if err != nil {
+			return nil, fmt.Errorf("variant %d: %w", i, err)
+		}
+		variants[i] = v
+	}
+
+	return variants, nil
+}
@@ -0,0 +1,149 @@
+package ase
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func indexedTestSprite(t *testing.T) *Sprite {
+	t.Helper()
+
+	palette := &ChunkPalette{
+		header:           ChunkHeader{Type: PaletteChunkHex},
+		ChunkPaletteData: ChunkPaletteData{EntriesNumber: 3, From: 0, To: 2},
+		Entries: []ChunkPaletteEntry{
+			{},
+			{Red: 0xFF, Alpha: 0xFF},
+			{Blue: 0xFF, Alpha: 0xFF},
+		},
+	}
+	cel := &ChunkCelImage{
+		header:       ChunkHeader{Type: CelChunkHex},
+		ChunkCelData: ChunkCelData{Opacity: 255},
+		ChunkCelRawImageData: ChunkCelRawImageData{
+			ChunkCelDimensionData: ChunkCelDimensionData{Width: 2, Height: 1},
+			Pixels:                &image.Paletted{Pix: []uint8{1, 2}, Stride: 2, Rect: image.Rect(0, 0, 2, 1), Palette: color.Palette{color.NRGBA{}, color.NRGBA{R: 0xFF, A: 0xFF}, color.NRGBA{B: 0xFF, A: 0xFF}}},
+		},
+	}
+	ase := &AsepriteFile{
+		Header: Header{Width: 2, Height: 1, ColorDepth: ColorDepthIndexed},
+		Frames: []Frame{
+			{Chunks: []Chunk{palette, &ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}, ChunkLayerFlags: ChunkLayerFlags{Visible: true}}, cel}},
+			{},
+		},
+	}
+
+	sprite, err := ase.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	return sprite
+}
+
+func TestRemapIndexed(t *testing.T) {
+	sprite := indexedTestSprite(t)
+	red, blue := sprite.Palette[1], sprite.Palette[2]
+
+	swapped, err := sprite.Remap(Remap{Indexes: map[uint8]uint8{1: 2, 2: 1}})
+	if err != nil {
+		t.Fatalf("failed to remap indexes: %v", err)
+	}
+
+	if got := swapped.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*image.Paletted).Pix; got[0] != 2 || got[1] != 1 {
+		t.Errorf("unexpected remapped indexes: %v", got)
+	}
+	if got := sprite.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*image.Paletted).Pix; got[0] != 1 {
+		t.Errorf("expected remapping to leave the sprite untouched, got %v", got)
+	}
+
+	green := PaletteEntry{NRGBA: color.NRGBA{G: 0xFF, A: 0xFF}}
+	recolored, err := sprite.Remap(Remap{Source: Palette{red}, Target: Palette{green}})
+	if err != nil {
+		t.Fatalf("failed to remap colors: %v", err)
+	}
+
+	if recolored.Palette[1] != green || recolored.Palette[2] != blue || sprite.Palette[1] != red {
+		t.Errorf("unexpected recolored palette: %v", recolored.Palette)
+	}
+	if p := recolored.PaletteAt(1); len(p) != 3 || &p[0] != &recolored.Frames[0].Palette[0] {
+		t.Errorf("expected frames without palette chunks to share the recolored palette")
+	}
+	got := recolored.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*image.Paletted)
+	if src := sprite.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*image.Paletted); &got.Pix[0] != &src.Pix[0] {
+		t.Errorf("expected unchanged pixels to be shared")
+	}
+	if got.Palette[1] != green.NRGBA {
+		t.Errorf("unexpected palette of the recolored image: %v", got.Palette[:3])
+	}
+
+	img, err := recolored.RenderFrame(0, RenderOptions{ColorManagement: ColorManagementNone})
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+	direct, err := sprite.RenderFrame(0, RenderOptions{ColorManagement: ColorManagementNone, Remap: &Remap{Source: Palette{red}, Target: Palette{green}}})
+	if err != nil {
+		t.Fatalf("failed to render with a remap: %v", err)
+	}
+	if img.RGBAAt(0, 0) != (color.RGBA{G: 0xFF, A: 0xFF}) || img.RGBAAt(0, 0) != direct.RGBAAt(0, 0) || img.RGBAAt(1, 0) != direct.RGBAAt(1, 0) {
+		t.Errorf("unexpected render: got %v %v, direct %v %v", img.RGBAAt(0, 0), img.RGBAAt(1, 0), direct.RGBAAt(0, 0), direct.RGBAAt(1, 0))
+	}
+}
+
+func TestRemapRGBA(t *testing.T) {
+	sprite := loadTestSprite(t)
+
+	pixels := sprite.Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*image.NRGBA).Pix
+	var from [4]byte
+	for i := 0; i < len(pixels); i += 4 {
+		if pixels[i+3] != 0 {
+			from = [4]byte(pixels[i : i+4])
+			break
+		}
+	}
+	source := Palette{{NRGBA: color.NRGBA{R: from[0], G: from[1], B: from[2], A: from[3]}}}
+
+	variants, err := sprite.Variants(source, []Palette{
+		{{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}}},
+		{{NRGBA: color.NRGBA{G: 0xFF, A: 0xFF}}},
+	})
+	if err != nil {
+		t.Fatalf("failed to build variants: %v", err)
+	}
+
+	for i, want := range [][4]byte{{0xFF, 0, 0, 0xFF}, {0, 0xFF, 0, 0xFF}} {
+		got := variants[i].Frames[0].Cels[0].Chunk.(*ChunkCelImage).Pixels.(*image.NRGBA).Pix
+		for j := 0; j < len(pixels); j += 4 {
+			c, g := [4]byte(pixels[j:j+4]), [4]byte(got[j:j+4])
+			if c == from && g != want || c != from && g != c {
+				t.Fatalf("variant %d: pixel %d is %v, was %v", i, j/4, g, c)
+			}
+		}
+
+		for _, frame := range variants[i].Frames {
+			for _, cel := range frame.Cels {
+				if cel.Link != nil && cel.Link.Frame >= len(variants[i].Frames) || cel.Link != nil && variants[i].Frames[cel.Link.Frame].Cel(cel.Layer) != cel.Link {
+					t.Fatalf("variant %d: linked cel points outside the variant", i)
+				}
+			}
+		}
+	}
+}
+
+func TestRemapInvalid(t *testing.T) {
+	sprite := loadTestSprite(t)
+
+	for _, r := range []Remap{
+		{Source: Palette{{}}, Target: Palette{}},
+		{Indexes: map[uint8]uint8{1: 2}},
+	} {
+		if _, err := sprite.Remap(r); !errors.Is(err, ErrInvalidRemap) {
+			t.Errorf("%+v: expected ErrInvalidRemap, got %v", r, err)
+		}
+		if _, err := sprite.RenderFrame(0, RenderOptions{Remap: &r}); !errors.Is(err, ErrInvalidRemap) {
+			t.Errorf("%+v: expected ErrInvalidRemap from RenderFrame, got %v", r, err)
+		}
+	}
+}
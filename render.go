@@ -0,0 +1,404 @@
+package ase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+var ErrUnsupportedColorDepth = errors.New("unsupported color depth")
+
+type RenderOptions struct {
+	// Scale multiplies the canvas size of the output, 0 means 1. Outputs
+	// larger than DefaultMaxCanvasWidth x DefaultMaxCanvasHeight fail with
+	// ErrLimitExceeded.
+	Scale float64
+	// PreciseBounds places cels that carry CelExtra precise bounds at their
+	// sub-pixel position and size instead of the integer cel position.
+	PreciseBounds bool
+	// ColorManagement selects how colors of the sprite color profile are
+	// handled, by default they are converted to sRGB.
+	ColorManagement ColorManagement
+	// Remap recolors the cels while rendering, without copying the sprite.
+	Remap *Remap
+}
+
+// renderState holds what RenderFrame works out once for all the cels of a
+// frame.
+type renderState struct {
+	opts   RenderOptions
+	scale  float64
+	conv   *colorConverter
+	colors map[color.NRGBA]color.NRGBA
+}
+
+// RenderFrame composites the visible image cels of a frame into a new image
+// of the canvas size times opts.Scale. Cels are sampled with nearest
+// neighbour and drawn in layer order, using the normal blend mode.
+// Tilemap cels are not rendered.
+//
+// Colors of profiles that can't be converted to sRGB are left untouched, as
+// with ColorManagementNone, and Sprite.Warnings says so.
+//
+// With SetRenderCache, frames rendered before with the same options are
+// copied from the cache instead.
+func (s *Sprite) RenderFrame(frame int, opts RenderOptions) (*image.RGBA, error) {
+	return s.RenderFrameContext(context.Background(), frame, opts)
+}
+
+// RenderFrameContext is RenderFrame stopping with ctx.Err() once ctx is
+// done, checked between cels.
+func (s *Sprite) RenderFrameContext(ctx context.Context, frame int, opts RenderOptions) (*image.RGBA, error) {
+	img, err := s.renderFrame(ctx, frame, opts)
+	if err != nil || s.cache == nil {
+		return img, err
+	}
+
+	// the cache keeps its own copy
+	out := *img
+	out.Pix = bytes.Clone(img.Pix)
+	return &out, nil
+}
+
+// renderFrame is RenderFrameContext returning the image of the render cache
+// itself when there is one.
+func (s *Sprite) renderFrame(ctx context.Context, frame int, opts RenderOptions) (*image.RGBA, error) {
+	if frame < 0 || frame >= len(s.Frames) {
+		return nil, fmt.Errorf("render: frame %d out of range [0, %d)", frame, len(s.Frames))
+	}
+
+	scale := opts.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	if scale < 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return nil, fmt.Errorf("render: invalid scale %v", opts.Scale)
+	}
+
+	// the output is bounded like the canvas of a decoded file, clamped
+	// first so a huge scale can't overflow
+	width := int(min(math.Round(float64(s.Width)*scale), math.MaxInt32))
+	height := int(min(math.Round(float64(s.Height)*scale), math.MaxInt32))
+	if err := checkLimit("MaxCanvasWidth", width, DefaultMaxCanvasWidth); err != nil {
+		return nil, fmt.Errorf("render: scale %v: %w", opts.Scale, err)
+	}
+	if err := checkLimit("MaxCanvasHeight", height, DefaultMaxCanvasHeight); err != nil {
+		return nil, fmt.Errorf("render: scale %v: %w", opts.Scale, err)
+	}
+
+	key := frameKey{frame: frame, opts: opts}
+	key.opts.Scale = scale
+	if s.cache != nil {
+		if img, ok := s.cache.frame(key); ok {
+			return img, nil
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	state := &renderState{opts: opts, scale: scale}
+	if opts.ColorManagement == ColorManagementSRGB {
+		// a profile that can't be converted passes colors through
+		state.conv, _ = s.srgbConverter()
+	}
+
+	if opts.Remap != nil {
+		if err := opts.Remap.check(s.ColorDepth); err != nil {
+			return nil, fmt.Errorf("render: %w", err)
+		}
+		state.colors = opts.Remap.colors()
+	}
+
+	cels := append([]*Cel(nil), s.Frames[frame].Cels...)
+	// same order Aseprite uses: layer index shifted by the z-index, ties go
+	// to the higher z-index
+	sort.SliceStable(cels, func(i, j int) bool {
+		a, b := cels[i], cels[j]
+		orderA := a.Layer.Index + int(a.Data().Z)
+		orderB := b.Layer.Index + int(b.Data().Z)
+		if orderA != orderB {
+			return orderA < orderB
+		}
+		return a.Data().Z < b.Data().Z
+	})
+
+	for _, cel := range cels {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !cel.Layer.IsVisible() || cel.Layer.ReferenceLayer {
+			continue
+		}
+
+		if err := s.renderCel(dst, cel, state); err != nil {
+			return nil, fmt.Errorf("render: frame %d: %w", frame, err)
+		}
+	}
+
+	if s.cache != nil {
+		s.cache.putFrame(key, dst)
+	}
+
+	return dst, nil
+}
+
+func (s *Sprite) renderCel(dst *image.RGBA, cel *Cel, state *renderState) error {
+	source := cel
+	if cel.Link != nil {
+		source = cel.Link
+	}
+
+	chunk, ok := source.Chunk.(*ChunkCelImage)
+	if !ok {
+		return nil
+	}
+
+	img, err := s.sourceImage(cel, chunk, state)
+	if err != nil {
+		return err
+	}
+
+	data := cel.Data()
+	bounds := PreciseRect{
+		X:      float64(data.X),
+		Y:      float64(data.Y),
+		Width:  float64(img.Rect.Dx()),
+		Height: float64(img.Rect.Dy()),
+	}
+	if state.opts.PreciseBounds {
+		if precise, ok := cel.PreciseBounds(); ok {
+			bounds = precise
+		} else if precise, ok := source.PreciseBounds(); ok {
+			bounds = precise
+		}
+	}
+
+	// without a mask opaque cels take the fast paths of image/draw
+	var mask image.Image
+	if opacity := s.celOpacity(cel); opacity != 0xFF {
+		mask = image.NewUniform(color.Alpha{A: opacity})
+	}
+
+	scale := state.scale
+	if scale == 1 && bounds.Width == float64(img.Rect.Dx()) && bounds.Height == float64(img.Rect.Dy()) &&
+		bounds.X == math.Trunc(bounds.X) && bounds.Y == math.Trunc(bounds.Y) {
+		r := image.Rect(0, 0, img.Rect.Dx(), img.Rect.Dy()).Add(image.Pt(int(bounds.X), int(bounds.Y)))
+		draw.DrawMask(dst, r, img, img.Rect.Min, mask, image.Point{}, draw.Over)
+		return nil
+	}
+
+	scaled := scaleNearest(img, bounds, scale, dst.Rect)
+	draw.DrawMask(dst, scaled.Rect, scaled, scaled.Rect.Min, mask, image.Point{}, draw.Over)
+
+	return nil
+}
+
+// sourceImage returns the image of chunk as cel shows it, with its colors
+// remapped and converted, before it is placed and scaled. Cels sharing the
+// same chunk, like linked cels, share the image through the render cache.
+// The image may be the pixels of the cel themselves, it must not be
+// modified.
+func (s *Sprite) sourceImage(cel *Cel, chunk *ChunkCelImage, state *renderState) (*image.NRGBA, error) {
+	if state.opts.Remap == nil && state.conv == nil {
+		if img, ok := ownImage(chunk); ok {
+			return img, nil
+		}
+	}
+
+	palette := s.PaletteAt(cel.Frame)
+
+	var key celKey
+	if s.cache != nil {
+		key = celKey{
+			chunk:           chunk,
+			background:      cel.Layer.Background,
+			remap:           state.opts.Remap,
+			colorManagement: state.opts.ColorManagement,
+		}
+		if s.ColorDepth == ColorDepthIndexed && len(palette) > 0 {
+			key.palette = &palette[0]
+		}
+
+		if img, ok := s.cache.cel(key); ok {
+			return img, nil
+		}
+	}
+
+	if remap := state.opts.Remap; remap != nil {
+		pixels, err := remap.pixels(chunk, state.colors)
+		if err != nil {
+			return nil, err
+		}
+		if pixels != nil {
+			chunk = chunk.withPixels(pixels)
+		}
+		if s.ColorDepth == ColorDepthIndexed {
+			palette = remap.palette(palette, state.colors)
+		}
+	}
+
+	img, err := s.celImage(chunk, palette, cel.Layer.Background)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.conv != nil {
+		state.conv.convert(img.Pix)
+	}
+
+	if s.cache != nil {
+		s.cache.putCel(key, img)
+	}
+
+	return img, nil
+}
+
+// ownImage returns the pixels of chunk sub-imaged to the cel size when they
+// are an *image.NRGBA, which is drawn without a copy.
+func ownImage(chunk *ChunkCelImage) (*image.NRGBA, bool) {
+	p, err := chunk.LoadPixels()
+	pixels, ok := p.(*image.NRGBA)
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	r := image.Rect(0, 0, int(chunk.Width), int(chunk.Height)).Add(pixels.Rect.Min)
+	if !r.In(pixels.Rect) {
+		return nil, false
+	}
+
+	return pixels.SubImage(r).(*image.NRGBA), true
+}
+
+// celOpacity combines the cel opacity with the opacity of its layer and
+// groups, when the header says those are valid.
+func (s *Sprite) celOpacity(cel *Cel) uint8 {
+	opacity := int(cel.Data().Opacity)
+
+	var flags uint32
+	if s.File != nil {
+		flags = s.File.Header.Flags
+	}
+
+	for layer := cel.Layer; layer != nil; layer = layer.Parent {
+		valid := HeaderFlagLayerOpacityValid
+		if layer.IsGroup() {
+			valid = HeaderFlagGroupOpacityValid
+		}
+
+		if flags&valid != 0 {
+			opacity = opacity * int(layer.ChunkLayerData.Opacity) / 255
+		}
+	}
+
+	return uint8(opacity)
+}
+
+// celImage converts the decoded pixels of a cel into a new image the size
+// of the cel. Indexed pixels are looked up in palette rather than in the
+// palette of the *image.Paletted, the transparent index is only opaque on
+// background layers.
+func (s *Sprite) celImage(chunk *ChunkCelImage, palette Palette, background bool) (*image.NRGBA, error) {
+	p, err := chunk.LoadPixels()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pixelsImage(p, int(chunk.Width), int(chunk.Height), palette, background)
+}
+
+// pixelsImage converts width x height decoded pixels into a new image, as
+// celImage does for cels.
+func (s *Sprite) pixelsImage(p Pixels, width, height int, palette Palette, background bool) (*image.NRGBA, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	src, ok := p.(image.Image)
+	if !ok {
+		return nil, fmt.Errorf("%w (%T)", ErrUnsupportedColorDepth, p)
+	}
+	if b := src.Bounds(); b.Dx() < width || b.Dy() < height {
+		return nil, fmt.Errorf("%w (got %dx%d pixels, want %dx%d)", ErrInvalidPixels, b.Dx(), b.Dy(), width, height)
+	}
+	origin := src.Bounds().Min
+
+	switch pixels := p.(type) {
+	case *image.NRGBA:
+		for y := range height {
+			i := pixels.PixOffset(origin.X, origin.Y+y)
+			copy(img.Pix[y*img.Stride:(y+1)*img.Stride], pixels.Pix[i:i+width*4])
+		}
+	case *GrayAlpha:
+		for y := range height {
+			i := pixels.PixOffset(origin.X, origin.Y+y)
+			for x := range width {
+				v, a := pixels.Pix[i+x*2], pixels.Pix[i+x*2+1]
+				j := y*img.Stride + x*4
+				img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = v, v, v, a
+			}
+		}
+	case *image.Paletted:
+		var transparent byte
+		if s.File != nil {
+			transparent = s.File.Header.PaletteEntry
+		}
+
+		for y := range height {
+			i := pixels.PixOffset(origin.X, origin.Y+y)
+			for x := range width {
+				index := pixels.Pix[i+x]
+				// indexes past the end of the palette stay transparent
+				if (index == transparent && !background) || int(index) >= len(palette) {
+					continue
+				}
+				c := palette[index]
+				j := y*img.Stride + x*4
+				img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = c.R, c.G, c.B, c.A
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w (%T)", ErrUnsupportedColorDepth, p)
+	}
+
+	return img, nil
+}
+
+// scaleNearest resamples img to cover bounds scaled by scale, in output
+// pixel coordinates. Only the part inside clip is produced.
+func scaleNearest(img *image.NRGBA, bounds PreciseRect, scale float64, clip image.Rectangle) *image.NRGBA {
+	r := image.Rect(
+		int(math.Round(bounds.X*scale)),
+		int(math.Round(bounds.Y*scale)),
+		int(math.Round((bounds.X+bounds.Width)*scale)),
+		int(math.Round((bounds.Y+bounds.Height)*scale)),
+	)
+
+	out := image.NewNRGBA(r.Intersect(clip))
+	srcWidth, srcHeight := img.Rect.Dx(), img.Rect.Dy()
+	if bounds.Width <= 0 || bounds.Height <= 0 || srcWidth == 0 || srcHeight == 0 {
+		return out
+	}
+
+	for y := out.Rect.Min.Y; y < out.Rect.Max.Y; y++ {
+		// sample at the pixel centre, mapped back into the cel
+		v := ((float64(y)+0.5)/scale - bounds.Y) / bounds.Height
+		sy := min(max(int(v*float64(srcHeight)), 0), srcHeight-1)
+
+		for x := out.Rect.Min.X; x < out.Rect.Max.X; x++ {
+			u := ((float64(x)+0.5)/scale - bounds.X) / bounds.Width
+			sx := min(max(int(u*float64(srcWidth)), 0), srcWidth-1)
+
+			si := img.PixOffset(img.Rect.Min.X+sx, img.Rect.Min.Y+sy)
+			di := out.PixOffset(x, y)
+			copy(out.Pix[di:di+4], img.Pix[si:si+4])
+		}
+	}
+
+	return out
+}
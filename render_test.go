@@ -0,0 +1,274 @@
+package ase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+func TestRenderFrame(t *testing.T) {
+	fd, err := os.Open(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to open file %s: %v", testFilePath, err)
+	}
+	defer fd.Close()
+
+	ase, err := DeserializeFile(fd)
+	if err != nil {
+		t.Fatalf("failed to deserialize file %s: %v", testFilePath, err)
+	}
+
+	sprite, err := ase.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	sheet, err := ase.SpriteSheet()
+	if err != nil {
+		t.Fatalf("failed to build sprite sheet: %v", err)
+	}
+
+	for i := range sprite.Frames {
+		img, err := sprite.RenderFrame(i, RenderOptions{})
+		if err != nil {
+			t.Fatalf("failed to render frame %d: %v", i, err)
+		}
+
+		scaled, err := sprite.RenderFrame(i, RenderOptions{Scale: 2})
+		if err != nil {
+			t.Fatalf("failed to render frame %d scaled: %v", i, err)
+		}
+
+		if scaled.Rect.Dx() != 2*sprite.Width || scaled.Rect.Dy() != 2*sprite.Height {
+			t.Fatalf("frame %d: unexpected scaled size %v", i, scaled.Rect)
+		}
+
+		for y := range sprite.Height {
+			for x := range sprite.Width {
+				want := sheet.At(i*sprite.Width+x, y)
+				if got := img.At(x, y); got != want {
+					t.Fatalf("frame %d: pixel (%d, %d) = %v, want %v", i, x, y, got, want)
+				}
+				if got := scaled.At(2*x+1, 2*y+1); got != want {
+					t.Fatalf("frame %d: scaled pixel (%d, %d) = %v, want %v", i, 2*x+1, 2*y+1, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderFrameScaleLimit(t *testing.T) {
+	sprite, err := linkedFile().Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	for _, scale := range []float64{DefaultMaxCanvasWidth, 1e300} {
+		if _, err := sprite.RenderFrame(0, RenderOptions{Scale: scale}); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("scale %v: expected ErrLimitExceeded, got %v", scale, err)
+		}
+	}
+
+	// 4x2 scaled to the largest width allowed
+	img, err := sprite.RenderFrame(0, RenderOptions{Scale: DefaultMaxCanvasWidth / 4})
+	if err != nil {
+		t.Fatalf("failed to render at the largest scale: %v", err)
+	}
+	if img.Rect.Dx() != DefaultMaxCanvasWidth {
+		t.Errorf("unexpected size %v", img.Rect)
+	}
+}
+
+func TestRenderFrameSubImage(t *testing.T) {
+	want, err := linkedFile().Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	// the same red cel, as a sub-image whose origin is not 0, 0
+	file := linkedFile()
+	big := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	for x := 1; x < 3; x++ {
+		big.SetNRGBA(x, 1, color.NRGBA{R: 0xFF, A: 0xFF})
+	}
+	cel := file.Frames[0].Chunks[1].(*ChunkCelImage)
+	cel.Pixels = big.SubImage(image.Rect(1, 1, 3, 2))
+	pix := bytes.Clone(big.Pix)
+
+	sprite, err := file.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	for i := range sprite.Frames {
+		for _, opts := range []RenderOptions{{}, {Scale: 2}} {
+			img, err := sprite.RenderFrame(i, opts)
+			if err != nil {
+				t.Fatalf("failed to render frame %d: %v", i, err)
+			}
+			wantImg, _ := want.RenderFrame(i, opts)
+			if !bytes.Equal(img.Pix, wantImg.Pix) {
+				t.Errorf("frame %d at scale %v: sub-image cel renders differently", i, opts.Scale)
+			}
+		}
+	}
+
+	if !bytes.Equal(big.Pix, pix) {
+		t.Errorf("rendering modified the pixels of the cel")
+	}
+}
+
+func TestRenderFramePreciseBounds(t *testing.T) {
+	red := [4]byte{0xFF, 0x00, 0x00, 0xFF}
+
+	layer := &ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}, ChunkLayerFlags: ChunkLayerFlags{Visible: true}}
+	cel := &ChunkCelImage{
+		header:       ChunkHeader{Type: CelChunkHex},
+		ChunkCelData: ChunkCelData{X: 1, Y: 1, Opacity: 255, CelType: CelTypeCompressedImage},
+		ChunkCelRawImageData: ChunkCelRawImageData{
+			ChunkCelDimensionData: ChunkCelDimensionData{Width: 2, Height: 2},
+			Pixels:                &image.NRGBA{Pix: bytes.Repeat(red[:], 4), Stride: 8, Rect: image.Rect(0, 0, 2, 2)},
+		},
+	}
+	extra := &ChunkCelExtra{
+		header: ChunkHeader{Type: CelExtraChunkHex},
+		ChunkCelExtraData: ChunkCelExtraData{
+			Flags:  CelExtraFlagPreciseBounds,
+			X:      FloatToFixed(1.5),
+			Y:      FloatToFixed(1),
+			Width:  FloatToFixed(2.5),
+			Height: FloatToFixed(2),
+		},
+	}
+
+	ase := &AsepriteFile{
+		Header: Header{Width: 8, Height: 8, ColorDepth: ColorDepthRGBA},
+		Frames: []Frame{{Chunks: []Chunk{layer, cel, extra}}},
+	}
+
+	sprite, err := ase.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	precise, ok := sprite.Frames[0].Cels[0].PreciseBounds()
+	if !ok || precise != (PreciseRect{X: 1.5, Y: 1, Width: 2.5, Height: 2}) {
+		t.Fatalf("unexpected precise bounds: %+v, %v", precise, ok)
+	}
+
+	tests := []struct {
+		opts RenderOptions
+		want image.Rectangle
+	}{
+		{RenderOptions{Scale: 2}, image.Rect(2, 2, 6, 6)},
+		{RenderOptions{Scale: 2, PreciseBounds: true}, image.Rect(3, 2, 8, 6)},
+	}
+
+	for _, tt := range tests {
+		img, err := sprite.RenderFrame(0, tt.opts)
+		if err != nil {
+			t.Fatalf("failed to render frame: %v", err)
+		}
+
+		for y := range img.Rect.Dy() {
+			for x := range img.Rect.Dx() {
+				want := color.RGBA{}
+				if image.Pt(x, y).In(tt.want) {
+					want = color.RGBA{R: 0xFF, A: 0xFF}
+				}
+				if got := img.RGBAAt(x, y); got != want {
+					t.Fatalf("%+v: pixel (%d, %d) = %v, want %v", tt.opts, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// paletteCycleFile has an indexed cel linked from a second frame whose
+// palette swaps the colors of the cel.
+func paletteCycleFile() *AsepriteFile {
+	red := ChunkPaletteEntry{Red: 0xFF, Alpha: 0xFF}
+	blue := ChunkPaletteEntry{Blue: 0xFF, Alpha: 0xFF}
+
+	return &AsepriteFile{
+		Header: Header{Width: 2, Height: 1, ColorDepth: ColorDepthIndexed},
+		Frames: []Frame{
+			{Chunks: []Chunk{
+				&ChunkPalette{
+					header:           ChunkHeader{Type: PaletteChunkHex},
+					ChunkPaletteData: ChunkPaletteData{EntriesNumber: 3, From: 0, To: 2},
+					Entries:          []ChunkPaletteEntry{{}, red, blue},
+				},
+				&ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}, ChunkLayerFlags: ChunkLayerFlags{Visible: true}},
+				&ChunkCelImage{
+					header:       ChunkHeader{Type: CelChunkHex},
+					ChunkCelData: ChunkCelData{Opacity: 255},
+					ChunkCelRawImageData: ChunkCelRawImageData{
+						ChunkCelDimensionData: ChunkCelDimensionData{Width: 2, Height: 1},
+						Pixels:                &image.Paletted{Pix: []uint8{0, 1}, Stride: 2, Rect: image.Rect(0, 0, 2, 1)},
+					},
+				},
+			}},
+			{Chunks: []Chunk{
+				// the cycle moves blue into entry 1
+				&ChunkPalette{
+					header:           ChunkHeader{Type: PaletteChunkHex},
+					ChunkPaletteData: ChunkPaletteData{EntriesNumber: 3, From: 1, To: 2},
+					Entries:          []ChunkPaletteEntry{blue, red},
+				},
+				&ChunkCelLinked{header: ChunkHeader{Type: CelChunkHex}, ChunkCelData: ChunkCelData{Opacity: 255, CelType: CelTypeLinked}},
+			}},
+			{},
+		},
+	}
+}
+
+func TestRenderFramePaletteCycle(t *testing.T) {
+	sprite, err := paletteCycleFile().Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	if sprite.PaletteAt(2)[1] != sprite.PaletteAt(1)[1] || sprite.PaletteAt(0)[1].B != 0 || sprite.PaletteAt(3) != nil {
+		t.Errorf("unexpected palettes: %v, %v, %v", sprite.PaletteAt(0), sprite.PaletteAt(1), sprite.PaletteAt(2))
+	}
+
+	want := []color.RGBA{{R: 0xFF, A: 0xFF}, {B: 0xFF, A: 0xFF}}
+	for frame, want := range want {
+		img, err := sprite.RenderFrame(frame, RenderOptions{})
+		if err != nil {
+			t.Fatalf("failed to render frame %d: %v", frame, err)
+		}
+
+		if got := img.RGBAAt(0, 0); got != (color.RGBA{}) {
+			t.Errorf("frame %d: expected the transparent index to stay transparent, got %v", frame, got)
+		}
+
+		if got := img.RGBAAt(1, 0); got != want {
+			t.Errorf("frame %d: got %v, want %v", frame, got, want)
+		}
+	}
+}
+
+func TestRenderFrameContext(t *testing.T) {
+	sprite := loadTestSprite(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := sprite.RenderFrameContext(ctx, 0, RenderOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	if _, err := sprite.File.SpriteSheetContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from SpriteSheetContext, got %v", err)
+	}
+
+	if _, err := sprite.RenderFrameContext(context.Background(), 0, RenderOptions{}); err != nil {
+		t.Errorf("failed to render: %v", err)
+	}
+}
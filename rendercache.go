@@ -0,0 +1,201 @@
+package ase
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"image"
+	"sync"
+)
+
+// renderCache memoizes composited frames, and the cel images they are
+// built from so linked cels reuse the image of the cel they link to. Both
+// evict the least recently used entry past their size.
+type renderCache struct {
+	mu     sync.Mutex
+	frames *lru[frameKey, *image.RGBA]
+	cels   *lru[celKey, *image.NRGBA]
+}
+
+type frameKey struct {
+	frame int
+	opts  RenderOptions
+}
+
+// celKey is what the image of a cel depends on, palette being the first
+// entry of the palette of its frame for indexed sprites: frames without
+// palette chunks share the palette slice of the frame before them.
+type celKey struct {
+	chunk           *ChunkCelImage
+	palette         *PaletteEntry
+	background      bool
+	remap           *Remap
+	colorManagement ColorManagement
+}
+
+// SetRenderCache makes RenderFrame keep up to frames composited frames,
+// evicting the least recently used one, along with the cel images of about
+// as many frames. 0 drops the cache.
+//
+// Cached frames are keyed by frame index and render options, a Remap by
+// its pointer. ConvertColorDepth drops the cache, call SetRenderCache again
+// after changing the sprite or a cached Remap any other way. It must not be
+// called while frames are being rendered.
+func (s *Sprite) SetRenderCache(frames int) {
+	if frames <= 0 {
+		s.cache = nil
+		return
+	}
+
+	s.cache = &renderCache{
+		frames: newLRU[frameKey, *image.RGBA](frames),
+		cels:   newLRU[celKey, *image.NRGBA](frames * max(len(s.Layers), 1)),
+	}
+}
+
+func (c *renderCache) frame(key frameKey) (*image.RGBA, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.frames.get(key)
+}
+
+func (c *renderCache) putFrame(key frameKey, img *image.RGBA) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.frames.put(key, img)
+}
+
+func (c *renderCache) cel(key celKey) (*image.NRGBA, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cels.get(key)
+}
+
+func (c *renderCache) putCel(key celKey, img *image.NRGBA) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cels.put(key, img)
+}
+
+// lru is a map bounded to size entries, evicting the least recently used.
+type lru[K comparable, V any] struct {
+	size  int
+	items map[K]*list.Element
+	// order has the most recently used entry in front
+	order list.List
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRU[K comparable, V any](size int) *lru[K, V] {
+	return &lru[K, V]{size: size, items: make(map[K]*list.Element)}
+}
+
+func (c *lru[K, V]) get(key K) (V, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *lru[K, V]) put(key K, value V) {
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+func (c *lru[K, V]) len() int {
+	return c.order.Len()
+}
+
+// DirtyRect returns the part of the output where frame differs from the
+// frame before it, what delta based exporters like GIF or APNG redraw. It
+// is the whole output for the first frame, and empty when the frame is the
+// same as the one before. Frames are rendered with opts, through the render
+// cache when one is set.
+func (s *Sprite) DirtyRect(frame int, opts RenderOptions) (image.Rectangle, error) {
+	img, err := s.renderFrame(context.Background(), frame, opts)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	if frame == 0 {
+		return img.Rect, nil
+	}
+
+	prev, err := s.renderFrame(context.Background(), frame-1, opts)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	return diffRect(prev, img), nil
+}
+
+// DirtyRects returns DirtyRect for every frame, rendering each frame once.
+func (s *Sprite) DirtyRects(opts RenderOptions) ([]image.Rectangle, error) {
+	rects := make([]image.Rectangle, len(s.Frames))
+
+	var prev *image.RGBA
+	for i := range s.Frames {
+		img, err := s.renderFrame(context.Background(), i, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if prev == nil {
+			rects[i] = img.Rect
+		} else {
+			rects[i] = diffRect(prev, img)
+		}
+		prev = img
+	}
+
+	return rects, nil
+}
+
+// diffRect returns the smallest rectangle holding every pixel that differs
+// between two images of the same bounds.
+func diffRect(a, b *image.RGBA) image.Rectangle {
+	var out image.Rectangle
+	width := a.Rect.Dx() * 4
+
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		i, j := a.PixOffset(a.Rect.Min.X, y), b.PixOffset(b.Rect.Min.X, y)
+		rowA, rowB := a.Pix[i:i+width], b.Pix[j:j+width]
+		if bytes.Equal(rowA, rowB) {
+			continue
+		}
+
+		first, last := 0, width/4-1
+		for bytes.Equal(rowA[first*4:first*4+4], rowB[first*4:first*4+4]) {
+			first++
+		}
+		for bytes.Equal(rowA[last*4:last*4+4], rowB[last*4:last*4+4]) {
+			last--
+		}
+
+		out = out.Union(image.Rect(a.Rect.Min.X+first, y, a.Rect.Min.X+last+1, y+1))
+	}
+
+	return out
+}
@@ -0,0 +1,164 @@
+package ase
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// linkedFile has a red 2x1 cel in its first frame, linked at the same
+// position from the second frame and two pixels to the right from the
+// third one.
+func linkedFile() *AsepriteFile {
+	linked := func(x int16) *ChunkCelLinked {
+		return &ChunkCelLinked{header: ChunkHeader{Type: CelChunkHex}, ChunkCelData: ChunkCelData{X: x, Opacity: 255, CelType: CelTypeLinked}}
+	}
+
+	return &AsepriteFile{
+		Header: Header{Width: 4, Height: 2, ColorDepth: ColorDepthRGBA},
+		Frames: []Frame{
+			{Chunks: []Chunk{
+				&ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}, ChunkLayerFlags: ChunkLayerFlags{Visible: true}},
+				&ChunkCelImage{
+					header:       ChunkHeader{Type: CelChunkHex},
+					ChunkCelData: ChunkCelData{Opacity: 255},
+					ChunkCelRawImageData: ChunkCelRawImageData{
+						ChunkCelDimensionData: ChunkCelDimensionData{Width: 2, Height: 1},
+						Pixels:                &image.NRGBA{Pix: []uint8{0xFF, 0, 0, 0xFF, 0xFF, 0, 0, 0xFF}, Stride: 8, Rect: image.Rect(0, 0, 2, 1)},
+					},
+				},
+			}},
+			{Chunks: []Chunk{linked(0)}},
+			{Chunks: []Chunk{linked(2)}},
+		},
+	}
+}
+
+func TestRenderCache(t *testing.T) {
+	for _, file := range []*AsepriteFile{linkedFile(), paletteCycleFile()} {
+		sprite, err := file.Sprite()
+		if err != nil {
+			t.Fatalf("failed to build sprite: %v", err)
+		}
+
+		var want []*image.RGBA
+		for i := range sprite.Frames {
+			img, err := sprite.RenderFrame(i, RenderOptions{})
+			if err != nil {
+				t.Fatalf("failed to render frame %d: %v", i, err)
+			}
+			want = append(want, img)
+		}
+
+		sprite.SetRenderCache(2)
+		for range 2 {
+			for i := range sprite.Frames {
+				img, err := sprite.RenderFrame(i, RenderOptions{Scale: 1})
+				if err != nil {
+					t.Fatalf("failed to render frame %d: %v", i, err)
+				}
+
+				if !reflect.DeepEqual(img, want[i]) {
+					t.Errorf("frame %d: cached render differs", i)
+				}
+
+				// the cache keeps its own copy
+				img.Pix[3] = 0x7F
+			}
+		}
+
+		// only the last two frames are kept
+		if sprite.cache.frames.len() != 2 {
+			t.Errorf("expected 2 cached frames, got %d", sprite.cache.frames.len())
+		}
+		if _, ok := sprite.cache.frame(frameKey{frame: 0, opts: RenderOptions{Scale: 1}}); ok {
+			t.Errorf("expected frame 0 to be evicted")
+		}
+	}
+
+	sprite, err := linkedFile().Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+	sprite.SetRenderCache(4)
+	for i := range sprite.Frames {
+		if _, err := sprite.RenderFrame(i, RenderOptions{}); err != nil {
+			t.Fatalf("failed to render frame %d: %v", i, err)
+		}
+	}
+
+	// RGBA cels are drawn from their own pixels
+	if n := sprite.cache.cels.len(); n != 0 {
+		t.Errorf("expected no cached cel image, got %d", n)
+	}
+
+	remap := &Remap{Source: Palette{{NRGBA: color.NRGBA{R: 0xFF, A: 0xFF}}}, Target: Palette{{NRGBA: color.NRGBA{G: 0xFF, A: 0xFF}}}}
+	for i := range sprite.Frames {
+		if _, err := sprite.RenderFrame(i, RenderOptions{Remap: remap}); err != nil {
+			t.Fatalf("failed to render frame %d: %v", i, err)
+		}
+	}
+
+	// linked cels reuse the image of the cel they link to
+	if n := sprite.cache.cels.len(); n != 1 {
+		t.Errorf("expected 1 cached cel image, got %d", n)
+	}
+
+	if err := sprite.ConvertColorDepth(ColorDepthGrayscale, ConvertOptions{}); err != nil {
+		t.Fatalf("failed to convert: %v", err)
+	}
+	if sprite.cache != nil {
+		t.Errorf("expected ConvertColorDepth to drop the cache")
+	}
+}
+
+func TestDirtyRects(t *testing.T) {
+	sprite, err := linkedFile().Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	rects, err := sprite.DirtyRects(RenderOptions{Scale: 2})
+	if err != nil {
+		t.Fatalf("failed to compute dirty rects: %v", err)
+	}
+
+	want := []image.Rectangle{image.Rect(0, 0, 8, 4), {}, image.Rect(0, 0, 8, 2)}
+	if !reflect.DeepEqual(rects, want) {
+		t.Errorf("got %v, want %v", rects, want)
+	}
+
+	sprite = loadTestSprite(t)
+	sprite.SetRenderCache(len(sprite.Frames))
+	for i := range sprite.Frames {
+		rect, err := sprite.DirtyRect(i, RenderOptions{})
+		if err != nil {
+			t.Fatalf("frame %d: failed to compute the dirty rect: %v", i, err)
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev, _ := sprite.RenderFrame(i-1, RenderOptions{})
+		img, _ := sprite.RenderFrame(i, RenderOptions{})
+		changed := false
+		for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+			for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+				differs := img.RGBAAt(x, y) != prev.RGBAAt(x, y)
+				if differs && !(image.Point{x, y}.In(rect)) {
+					t.Fatalf("frame %d: pixel %d,%d changed outside %v", i, x, y, rect)
+				}
+				changed = changed || differs
+			}
+		}
+
+		if changed == rect.Empty() {
+			t.Errorf("frame %d: dirty rect %v, changed %v", i, rect, changed)
+		}
+	}
+
+	if _, err := sprite.DirtyRect(len(sprite.Frames), RenderOptions{}); err == nil {
+		t.Errorf("expected an error for a frame out of range")
+	}
+}
@@ -0,0 +1,332 @@
+package ase
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	LayerTypeImage uint16 = iota
+	LayerTypeGroup
+	LayerTypeTilemap
+)
+
+// Sprite is the document view of an AsepriteFile: chunks are resolved into
+// layers, frames with their cels, tags, slices and tilesets, and every user
+// data chunk is attached to the object it describes.
+type Sprite struct {
+	File       *AsepriteFile
+	Width      int
+	Height     int
+	ColorDepth ColorDepth
+	Layers     []*Layer
+	Frames     []*SpriteFrame
+	Tags       []*Tag
+	Slices     []*Slice
+	Tilesets   []*Tileset
+	UserData   *ChunkUserData
+	// Palette is the palette of the first frame, see PaletteAt for the
+	// palette of later frames.
+	Palette Palette
+	// ColorProfile is the *ChunkColorProfile or *ChunkColorProfileICC of the
+	// file, nil when it has none.
+	ColorProfile Chunk
+	// Warnings lists what RenderFrame works around, like a color profile it
+	// can't convert to sRGB and leaves untouched.
+	Warnings []Warning
+
+	// cache is set by SetRenderCache.
+	cache *renderCache
+	// srgb keeps the converter of ColorProfile, see srgbConverter.
+	srgb *srgbCache
+}
+
+type Layer struct {
+	*ChunkLayer
+	Index int
+	// Parent is the group holding the layer, nil for top level layers.
+	Parent   *Layer
+	UserData *ChunkUserData
+}
+
+func (l *Layer) Name() string {
+	return string(l.ChunkLayerName)
+}
+
+func (l *Layer) IsGroup() bool {
+	return l.ChunkLayerData.Type == LayerTypeGroup
+}
+
+// IsVisible reports whether the layer and every group above it are visible.
+func (l *Layer) IsVisible() bool {
+	for layer := l; layer != nil; layer = layer.Parent {
+		if !layer.Visible {
+			return false
+		}
+	}
+
+	return true
+}
+
+type SpriteFrame struct {
+	Index    int
+	Duration time.Duration
+	// Palette is the palette in effect for the frame. Frames without
+	// palette chunks share the palette of the frame before them.
+	Palette Palette
+	// Cels are kept in the order they appear in the file.
+	Cels []*Cel
+}
+
+// PaletteAt returns the palette in effect at the given frame: the palette of
+// the first frame with the entries changed by the palette chunks of every
+// frame up to it. It returns nil for frames out of range.
+func (s *Sprite) PaletteAt(frame int) Palette {
+	if frame < 0 || frame >= len(s.Frames) {
+		return nil
+	}
+
+	return s.Frames[frame].Palette
+}
+
+// Cel returns the cel of the frame on the given layer, or nil.
+func (f *SpriteFrame) Cel(layer *Layer) *Cel {
+	for _, cel := range f.Cels {
+		if cel.Layer == layer {
+			return cel
+		}
+	}
+
+	return nil
+}
+
+type Cel struct {
+	// Chunk is a *ChunkCelImage, *ChunkCelLinked or *ChunkCelTilemap.
+	Chunk    Chunk
+	Layer    *Layer
+	Frame    int
+	UserData *ChunkUserData
+	// Link is the cel a linked cel takes its content from, nil otherwise.
+	Link *Cel
+	// Extra is the CelExtra chunk that followed the cel, if any.
+	Extra *ChunkCelExtra
+}
+
+// PreciseRect is a cel position and size in sub-pixel canvas units.
+type PreciseRect struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+}
+
+const CelExtraFlagPreciseBounds uint32 = 1 << 0
+
+func (c *Cel) Data() ChunkCelData {
+	switch chunk := c.Chunk.(type) {
+	case *ChunkCelImage:
+		return chunk.ChunkCelData
+	case *ChunkCelLinked:
+		return chunk.ChunkCelData
+	case *ChunkCelTilemap:
+		return chunk.ChunkCelData
+	}
+
+	return ChunkCelData{}
+}
+
+// PreciseBounds returns the sub-pixel bounds stored in the CelExtra chunk of
+// the cel. ok is false when the cel has none or its flag says they are unset.
+func (c *Cel) PreciseBounds() (rect PreciseRect, ok bool) {
+	if c.Extra == nil || c.Extra.Flags&CelExtraFlagPreciseBounds == 0 {
+		return PreciseRect{}, false
+	}
+
+	return PreciseRect{
+		X:      c.Extra.X.FixedToFloat(),
+		Y:      c.Extra.Y.FixedToFloat(),
+		Width:  c.Extra.Width.FixedToFloat(),
+		Height: c.Extra.Height.FixedToFloat(),
+	}, true
+}
+
+type Tag struct {
+	ChunkTagEntry
+	UserData *ChunkUserData
+}
+
+type Slice struct {
+	*ChunkSlice
+	UserData *ChunkUserData
+}
+
+type Tileset struct {
+	*ChunkTileset
+	UserData *ChunkUserData
+	// Tiles holds the tiles that have user data, in tile order.
+	Tiles []*Tile
+}
+
+type Tile struct {
+	Index    int
+	UserData *ChunkUserData
+}
+
+// userDataAttacher hands each user data chunk to the object it follows:
+// the last layer, cel or slice, each tag of a tags chunk in turn, a tileset
+// and then its tiles, or the sprite after the palette of the first frame.
+type userDataAttacher struct {
+	queue   []func(*ChunkUserData)
+	tileset *Tileset
+}
+
+func (a *userDataAttacher) reset(targets ...func(*ChunkUserData)) {
+	a.queue = targets
+	a.tileset = nil
+}
+
+func (a *userDataAttacher) attach(userData *ChunkUserData) {
+	if len(a.queue) > 0 {
+		a.queue[0](userData)
+		a.queue = a.queue[1:]
+		return
+	}
+
+	if a.tileset != nil && uint32(len(a.tileset.Tiles)) < a.tileset.TilesNumber {
+		a.tileset.Tiles = append(a.tileset.Tiles, &Tile{Index: len(a.tileset.Tiles), UserData: userData})
+	}
+}
+
+// layerTree builds the layers of a file from its layer chunks, in file
+// order.
+type layerTree struct {
+	layers []*Layer
+	// groups[level] is the last group seen at that child level
+	groups []*Layer
+}
+
+func (t *layerTree) add(c *ChunkLayer) *Layer {
+	layer := &Layer{ChunkLayer: c, Index: len(t.layers)}
+	level := int(c.ChunkLayerData.ChildLevel)
+	if level > 0 && level <= len(t.groups) {
+		layer.Parent = t.groups[level-1]
+	}
+	t.groups = append(t.groups[:min(level, len(t.groups))], layer)
+	t.layers = append(t.layers, layer)
+
+	return layer
+}
+
+// Sprite builds the document view of the file.
+func (a *AsepriteFile) Sprite() (*Sprite, error) {
+	s := &Sprite{
+		File:       a,
+		Width:      int(a.Header.Width),
+		Height:     int(a.Header.Height),
+		ColorDepth: a.Header.ColorDepth,
+	}
+	s.srgb = new(srgbCache)
+
+	var layers layerTree
+	var attacher userDataAttacher
+	var lastCel *Cel
+	var palette Palette
+	var profileFrame int
+
+	for i, frame := range a.Frames {
+		duration := frame.Header.FrameDuration
+		if duration == 0 {
+			duration = a.Header.FrameSpeed
+		}
+
+		palette = framePalette(palette, frame.Chunks)
+		if i == 0 {
+			s.Palette = palette
+		}
+
+		spriteFrame := &SpriteFrame{Index: i, Duration: time.Duration(duration) * time.Millisecond, Palette: palette}
+		s.Frames = append(s.Frames, spriteFrame)
+
+		for _, chunk := range frame.Chunks {
+			var cel *Cel
+
+			switch c := chunk.(type) {
+			case *ChunkUserData:
+				attacher.attach(c)
+			case *ChunkLayer:
+				layer := layers.add(c)
+				s.Layers = layers.layers
+				attacher.reset(func(u *ChunkUserData) { layer.UserData = u })
+			case *ChunkCelImage, *ChunkCelLinked, *ChunkCelTilemap:
+				cel = &Cel{Chunk: chunk, Frame: i}
+				layerIndex := int(cel.Data().LayerIndex)
+				if layerIndex >= len(s.Layers) {
+					return nil, fmt.Errorf("sprite: cel in frame %d references missing layer %d", i, layerIndex)
+				}
+				cel.Layer = s.Layers[layerIndex]
+				spriteFrame.Cels = append(spriteFrame.Cels, cel)
+				attacher.reset(func(u *ChunkUserData) { cel.UserData = u })
+			case *ChunkTag:
+				var targets []func(*ChunkUserData)
+				for _, entry := range c.Entries {
+					tag := &Tag{ChunkTagEntry: entry}
+					s.Tags = append(s.Tags, tag)
+					targets = append(targets, func(u *ChunkUserData) { tag.UserData = u })
+				}
+				attacher.reset(targets...)
+			case *ChunkSlice:
+				slice := &Slice{ChunkSlice: c}
+				s.Slices = append(s.Slices, slice)
+				attacher.reset(func(u *ChunkUserData) { slice.UserData = u })
+			case *ChunkTileset:
+				tileset := &Tileset{ChunkTileset: c}
+				s.Tilesets = append(s.Tilesets, tileset)
+				attacher.reset(func(u *ChunkUserData) { tileset.UserData = u })
+				attacher.tileset = tileset
+			case *ChunkPalette, *ChunkOldPalette, *ChunkOldPalette2:
+				if i == 0 {
+					attacher.reset(func(u *ChunkUserData) { s.UserData = u })
+				} else {
+					attacher.reset()
+				}
+			case *ChunkColorProfile, *ChunkColorProfileICC:
+				s.ColorProfile, profileFrame = chunk, i
+				attacher.reset()
+			case *ChunkCelExtra:
+				// describes the last cel, user data may still follow for it
+				if lastCel != nil {
+					lastCel.Extra = c
+				}
+			default:
+				attacher.reset()
+			}
+
+			lastCel = cel
+		}
+	}
+
+	for _, frame := range s.Frames {
+		for _, cel := range frame.Cels {
+			linked, ok := cel.Chunk.(*ChunkCelLinked)
+			if !ok {
+				continue
+			}
+
+			position := int(linked.FramePosition)
+			if position < 0 || position >= len(s.Frames) {
+				return nil, fmt.Errorf("sprite: cel in frame %d links to missing frame %d", frame.Index, position)
+			}
+
+			cel.Link = s.Frames[position].Cel(cel.Layer)
+			if cel.Link == nil {
+				return nil, fmt.Errorf("sprite: cel in frame %d links to an empty cel in frame %d", frame.Index, position)
+			}
+		}
+	}
+
+	if _, err := s.srgbConverter(); err != nil {
+		s.Warnings = append(s.Warnings, Warning{Frame: profileFrame, Chunk: ColorProfileChunkHex, Message: "colors left untouched: " + err.Error()})
+	}
+
+	return s, nil
+}
@@ -0,0 +1,128 @@
+package ase
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSpriteFromFile(t *testing.T) {
+	fd, err := os.Open(testFilePath)
+	if err != nil {
+		t.Fatalf("failed to open file %s: %v", testFilePath, err)
+	}
+	defer fd.Close()
+
+	ase, err := DeserializeFile(fd)
+	if err != nil {
+		t.Fatalf("failed to deserialize file %s: %v", testFilePath, err)
+	}
+
+	sprite, err := ase.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	if len(sprite.Layers) != 1 || sprite.Layers[0].Name() != "slime" {
+		t.Fatalf("unexpected layers: %v", sprite.Layers)
+	}
+
+	if sprite.Layers[0].UserData == nil || sprite.Layers[0].UserData.Text != "teste=1" {
+		t.Errorf("expected layer user data with text %q, got %v", "teste=1", sprite.Layers[0].UserData)
+	}
+
+	// the file only has the old palette chunk, with 32 colors
+	if len(sprite.Palette) != 32 {
+		t.Errorf("unexpected palette size: got %d, want %d", len(sprite.Palette), 32)
+	}
+
+	if len(sprite.Frames) != 8 {
+		t.Fatalf("unexpected number of frames: got %d, want %d", len(sprite.Frames), 8)
+	}
+
+	for _, frame := range sprite.Frames {
+		if frame.Duration != 250*time.Millisecond {
+			t.Errorf("frame %d: unexpected duration: got %v, want %v", frame.Index, frame.Duration, 250*time.Millisecond)
+		}
+
+		if cel := frame.Cel(sprite.Layers[0]); cel == nil || cel.UserData != nil {
+			t.Errorf("frame %d: expected a cel without user data, got %v", frame.Index, cel)
+		}
+	}
+}
+
+func TestSpriteUserData(t *testing.T) {
+	userData := func(text string) *ChunkUserData {
+		return &ChunkUserData{header: ChunkHeader{Type: UserDataChunkHex}, Text: text}
+	}
+
+	layer := &ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}}
+	group := &ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}, ChunkLayerData: ChunkLayerData{Type: LayerTypeGroup}}
+	child := &ChunkLayer{header: ChunkHeader{Type: LayerChunkHex}, ChunkLayerData: ChunkLayerData{ChildLevel: 1}}
+	tileset := &ChunkTileset{header: ChunkHeader{Type: TilesetChunkHex}, ChunkTilesetData: ChunkTilesetData{TilesNumber: 2}}
+	cel := &ChunkCelImage{header: ChunkHeader{Type: CelChunkHex}, ChunkCelData: ChunkCelData{LayerIndex: 2}}
+	linked := &ChunkCelLinked{header: ChunkHeader{Type: CelChunkHex}, ChunkCelData: ChunkCelData{LayerIndex: 2, CelType: CelTypeLinked}}
+
+	ase := &AsepriteFile{
+		Frames: []Frame{
+			{Chunks: []Chunk{
+				&ChunkPalette{header: ChunkHeader{Type: PaletteChunkHex}},
+				userData("sprite"),
+				tileset,
+				userData("tileset"),
+				userData("tile 0"),
+				userData("tile 1"),
+				userData("tile 2"),
+				layer,
+				group,
+				userData("group"),
+				child,
+				&ChunkTag{header: ChunkHeader{Type: TagsChunkHex}, Entries: []ChunkTagEntry{{Name: "idle"}, {Name: "run"}}},
+				userData("idle"),
+				userData("run"),
+				cel,
+				&ChunkCelExtra{header: ChunkHeader{Type: CelExtraChunkHex}},
+				userData("cel"),
+				&ChunkSlice{header: ChunkHeader{Type: SliceChunkHex}, Name: "hitbox"},
+				userData("slice"),
+			}},
+			{Chunks: []Chunk{linked}},
+		},
+	}
+
+	sprite, err := ase.Sprite()
+	if err != nil {
+		t.Fatalf("failed to build sprite: %v", err)
+	}
+
+	texts := map[string]*ChunkUserData{
+		"sprite":  sprite.UserData,
+		"tileset": sprite.Tilesets[0].UserData,
+		"group":   sprite.Layers[1].UserData,
+		"idle":    sprite.Tags[0].UserData,
+		"run":     sprite.Tags[1].UserData,
+		"cel":     sprite.Frames[0].Cels[0].UserData,
+		"slice":   sprite.Slices[0].UserData,
+	}
+	for text, got := range texts {
+		if got == nil || got.Text != text {
+			t.Errorf("expected user data %q, got %v", text, got)
+		}
+	}
+
+	if sprite.Layers[0].UserData != nil || sprite.Layers[2].UserData != nil {
+		t.Errorf("expected layers without user data")
+	}
+
+	if len(sprite.Tilesets[0].Tiles) != 2 || sprite.Tilesets[0].Tiles[1].UserData.Text != "tile 1" {
+		t.Errorf("unexpected tiles: %v", sprite.Tilesets[0].Tiles)
+	}
+
+	if sprite.Layers[2].Parent != sprite.Layers[1] || sprite.Layers[1].Parent != nil {
+		t.Errorf("expected layer 2 to be a child of group layer 1")
+	}
+
+	if link := sprite.Frames[1].Cels[0].Link; link != sprite.Frames[0].Cels[0] {
+		t.Errorf("expected linked cel to point at the cel of frame 0, got %v", link)
+	}
+}
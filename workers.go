@@ -0,0 +1,206 @@
+package ase
+
+import (
+	"bytes"
+	"image/color"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// celJob is an image cel whose pixel data is decoded by a worker while the
+// rest of the file is read.
+type celJob struct {
+	index      int
+	chunk      *ChunkCelImage
+	frame      int
+	compressed bool
+	data       []byte
+	palette    color.Palette
+	// warningAt is how many file warnings came before the cel, so its own
+	// warnings keep the place they get when decoding serially.
+	warningAt int
+
+	pixels   Pixels
+	warnings []Warning
+	err      error
+}
+
+// celQueue hands the image cels of a file to the workers of
+// DecodeOptions.Workers as they are read.
+type celQueue struct {
+	jobs []*celJob
+	// inflaters holds one inflater per worker, kept by a Decoder.
+	inflaters []inflater
+
+	// queue feeds the workers, it is nil until the first cel is queued
+	queue chan *celJob
+	wg    sync.WaitGroup
+	// failed is the index of the first cel that failed so far. Cels after
+	// it are skipped, the ones before it still run so the error reported
+	// is the first one in file order.
+	failed atomic.Int64
+}
+
+func (o DecodeOptions) workers() int {
+	if o.Workers < 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+
+	return o.Workers
+}
+
+// queueCelImage reads the pixel data of a cel and hands it to a worker,
+// decodeCels waits for it.
+func (l *Loader) queueCelImage(ch ChunkHeader, cData ChunkCelData, dimensions ChunkCelDimensionData, compressed bool, pixelDataSize, frameId int) (*ChunkCelImage, error) {
+	data, err := l.readBytes(pixelDataSize)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &celJob{
+		index: len(l.cels.jobs),
+		chunk: &ChunkCelImage{
+			header:       ch,
+			ChunkCelData: cData,
+			ChunkCelRawImageData: ChunkCelRawImageData{
+				ChunkCelDimensionData: dimensions,
+			},
+		},
+		frame:      frameId,
+		compressed: compressed,
+		data:       data,
+		warningAt:  len(l.File.Warnings),
+	}
+	if l.File.Header.ColorDepth == ColorDepthIndexed {
+		job.palette = l.imagePalette()
+	}
+
+	l.cels.jobs = append(l.cels.jobs, job)
+
+	if l.cels.queue == nil {
+		l.startWorkers()
+	}
+	if int64(job.index) > l.cels.failed.Load() {
+		return job.chunk, nil
+	}
+
+	select {
+	case l.cels.queue <- job:
+	case <-l.context().Done():
+	}
+
+	return job.chunk, nil
+}
+
+// startWorkers starts the pool decoding the queued cels. The workers share
+// the decompression budget of the file.
+func (l *Loader) startWorkers() {
+	q := l.cels
+	workers := l.Options.workers()
+	if n := workers - len(q.inflaters); n > 0 {
+		q.inflaters = append(q.inflaters, make([]inflater, n)...)
+	}
+
+	q.failed.Store(math.MaxInt64)
+	q.queue = make(chan *celJob, workers)
+	ctx := l.context()
+	header, budget := l.File.Header, l.decompressBudget()
+	options := l.Options
+	options.Workers = 0
+
+	for w := range workers {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for job := range q.queue {
+				if ctx.Err() != nil || int64(job.index) > q.failed.Load() {
+					continue
+				}
+
+				job.decode(header, options, budget, &q.inflaters[w])
+				if job.err != nil {
+					q.fail(job.index)
+				}
+			}
+		}()
+	}
+}
+
+func (q *celQueue) fail(i int) {
+	for {
+		f := q.failed.Load()
+		if int64(i) >= f || q.failed.CompareAndSwap(f, int64(i)) {
+			return
+		}
+	}
+}
+
+// decodeCels waits for the queued cels and stops the workers, leaving the
+// queue empty for the next cels. The first error in file order is
+// returned, and the cel warnings are merged into the file warnings in the
+// order serial decoding gives them.
+func (l *Loader) decodeCels() error {
+	q := l.cels
+	jobs := q.jobs
+	if q.queue == nil {
+		return nil
+	}
+
+	close(q.queue)
+	q.wg.Wait()
+	q.queue = nil
+	defer func() {
+		clear(q.jobs)
+		q.jobs = q.jobs[:0]
+	}()
+
+	var warnings []Warning
+	next := 0
+	for _, job := range jobs {
+		if job.err != nil {
+			return job.err
+		}
+		if job.pixels == nil {
+			// only cels after a failed one are skipped, unless ctx is done
+			return l.context().Err()
+		}
+
+		job.chunk.Pixels = job.pixels
+		job.chunk.Warnings = job.warnings
+
+		if len(job.warnings) > 0 {
+			warnings = append(warnings, l.File.Warnings[next:job.warningAt]...)
+			warnings = append(warnings, job.warnings...)
+			next = job.warningAt
+		}
+	}
+
+	if warnings != nil {
+		l.File.Warnings = append(warnings, l.File.Warnings[next:]...)
+	}
+
+	return nil
+}
+
+// decode runs on a worker: it decodes the pixels of the cel the way
+// parseCelImage does, on a loader of its own inflating with f.
+func (job *celJob) decode(header Header, options DecodeOptions, budget *budget, f *inflater) {
+	worker := &Loader{
+		Buffer:   bytes.NewBuffer(job.data),
+		File:     &AsepriteFile{Header: header},
+		Options:  options,
+		budget:   budget,
+		colors:   job.palette,
+		inflater: f,
+	}
+
+	chunk, err := worker.parseCelImage(job.chunk.header, job.chunk.ChunkCelData, job.chunk.ChunkCelDimensionData, job.compressed, len(job.data), job.frame)
+	if err != nil {
+		job.err = err
+		return
+	}
+
+	job.pixels, job.warnings, job.data = chunk.Pixels, chunk.Warnings, nil
+}